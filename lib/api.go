@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// API exposes the state of a Reconciler over HTTP
+// and allows reconciliations to be triggered on
+// demand.
+//
+//	GET  /status             last status (errors, timestamps and results)
+//	GET  /evaluations        evaluations computed in the last successful full pass
+//	GET  /autoscalinggroups  autoscaling groups observed in the last successful full pass
+//	POST /reconcile          performs a pass right away and returns the new status
+//	POST /sns                receives autoscaling notifications from SNS (if configured)
+type API struct {
+	logger           zerolog.Logger
+	reconciler       *Reconciler
+	reconcileTimeout time.Duration
+	mux              *http.ServeMux
+}
+
+type APIConfig struct {
+	Reconciler *Reconciler
+
+	// ReconcileTimeout is the maximum time that a
+	// POST /reconcile waits for a pass to finish.
+	ReconcileTimeout time.Duration
+
+	// SNS, if set, is served under /sns.
+	SNS *SNSHandler
+}
+
+func NewAPI(cfg APIConfig) (api *API, err error) {
+	if cfg.Reconciler == nil {
+		err = errors.Errorf("Reconciler must be specified")
+		return
+	}
+
+	api = &API{
+		reconciler:       cfg.Reconciler,
+		reconcileTimeout: cfg.ReconcileTimeout,
+		mux:              http.NewServeMux(),
+	}
+
+	if api.reconcileTimeout == 0 {
+		api.reconcileTimeout = 5 * time.Minute
+	}
+
+	api.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "api").
+		Logger()
+
+	api.mux.HandleFunc("/status", api.handleStatus)
+	api.mux.HandleFunc("/evaluations", api.handleEvaluations)
+	api.mux.HandleFunc("/autoscalinggroups", api.handleAutoScalingGroups)
+	api.mux.HandleFunc("/reconcile", api.handleReconcile)
+
+	if cfg.SNS != nil {
+		api.mux.Handle("/sns", cfg.SNS)
+	}
+
+	return
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+func (api *API) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	api.respond(w, http.StatusOK, api.reconciler.Status())
+}
+
+func (api *API) handleEvaluations(w http.ResponseWriter, r *http.Request) {
+	var evals = []*Evaluation{}
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	status := api.reconciler.Status()
+	if status.Reconciliation != nil {
+		evals = status.Reconciliation.Evaluations
+	}
+
+	api.respond(w, http.StatusOK, evals)
+}
+
+func (api *API) handleAutoScalingGroups(w http.ResponseWriter, r *http.Request) {
+	var asgs = map[string]*AutoScalingGroup{}
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	status := api.reconciler.Status()
+	if status.Reconciliation != nil {
+		asgs = status.Reconciliation.AutoScalingGroups
+	}
+
+	api.respond(w, http.StatusOK, asgs)
+}
+
+func (api *API) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), api.reconcileTimeout)
+	defer cancel()
+
+	status, err := api.reconciler.Trigger(ctx)
+	if err != nil {
+		api.respond(w, http.StatusServiceUnavailable, map[string]string{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if status.LastError != "" {
+		api.respond(w, http.StatusInternalServerError, status)
+		return
+	}
+
+	api.respond(w, http.StatusOK, status)
+}
+
+func (api *API) respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		api.logger.Error().
+			Err(err).
+			Msg("failed to encode response")
+	}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
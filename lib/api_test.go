@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI(t *testing.T, r *Reconciler) *API {
+	api, err := NewAPI(APIConfig{
+		Reconciler:       r,
+		ReconcileTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	return api
+}
+
+func serveTestRequest(api *API, method, path string) (w *httptest.ResponseRecorder) {
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return
+}
+
+func TestAPI(t *testing.T) {
+	var (
+		route53Client = &fakeRoute53{}
+		ctx, cancel   = context.WithCancel(context.Background())
+		body          map[string]interface{}
+	)
+	defer cancel()
+
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(route53Client),
+		Interval: time.Hour,
+		Dry:      true,
+	})
+	require.NoError(t, err)
+
+	api, err := NewAPI(APIConfig{Reconciler: r})
+	require.NoError(t, err)
+
+	// nothing has been observed before the first pass.
+	w := serveTestRequest(api, http.MethodGet, "/evaluations")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	w = serveTestRequest(api, http.MethodGet, "/autoscalinggroups")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{}`, w.Body.String())
+
+	done := runReconciler(ctx, r)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	w = serveTestRequest(api, http.MethodPost, "/reconcile")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "", body["LastError"])
+	assert.Equal(t, true, body["Dry"])
+
+	w = serveTestRequest(api, http.MethodGet, "/status")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotNil(t, body["Reconciliation"])
+
+	var evals []map[string]interface{}
+
+	w = serveTestRequest(api, http.MethodGet, "/evaluations")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &evals))
+	require.Len(t, evals, 1)
+	assert.Equal(t, "web", evals[0]["Record"].(map[string]interface{})["Name"])
+
+	var asgs map[string]interface{}
+
+	w = serveTestRequest(api, http.MethodGet, "/autoscalinggroups")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &asgs))
+	assert.Contains(t, asgs, "asg1")
+
+	setListError(route53Client, "zone1",
+		awserr.New(route53.ErrCodeNoSuchHostedZone, "no such zone", nil))
+
+	w = serveTestRequest(api, http.MethodPost, "/reconcile")
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Contains(t, body["LastError"], "no such zone")
+
+	// the results of the last successful pass are
+	// still served.
+	w = serveTestRequest(api, http.MethodGet, "/evaluations")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &evals))
+	assert.Len(t, evals, 1)
+}
+
+func TestAPIReconcileUnavailable(t *testing.T) {
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(&fakeRoute53{}),
+		Interval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	// the reconciler isn't running, so the trigger is
+	// never accepted.
+	w := serveTestRequest(newTestAPI(t, r), http.MethodPost, "/reconcile")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "reconciler didn't accept the trigger")
+}
+
+func TestAPIMethods(t *testing.T) {
+	var testCases = []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{method: http.MethodPost, path: "/status", allow: http.MethodGet},
+		{method: http.MethodDelete, path: "/evaluations", allow: http.MethodGet},
+		{method: http.MethodPut, path: "/autoscalinggroups", allow: http.MethodGet},
+		{method: http.MethodGet, path: "/reconcile", allow: http.MethodPost},
+	}
+
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(&fakeRoute53{}),
+		Interval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	api := newTestAPI(t, r)
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			w := serveTestRequest(api, tc.method, tc.path)
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, tc.allow, w.Header().Get("Allow"))
+		})
+	}
+}
@@ -2,35 +2,138 @@ package lib
 
 import (
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
 type Auto struct {
 	logger          zerolog.Logger
-	route53         *route53.Route53
-	ec2             *ec2.EC2
+	owner           string
+	route53         route53iface.Route53API
+	ec2             ec2iface.EC2API
+	autoscaling     autoscalingiface.AutoScalingAPI
 	formattingRules []*FormattingRule
+	rulesMtx        *sync.RWMutex
+
+	// zoneCache holds the IDs of the zones specified
+	// by name, kept between passes.
+	zoneCache *zoneCache
+
+	route53Limiter    *rateLimiter
+	route53MaxRetries int
+	route53Backoff    time.Duration
+
+	concurrency int
+
+	waitInSync       bool
+	waitTimeout      time.Duration
+	waitPollInterval time.Duration
 }
 
 type AutoConfig struct {
 	FormattingRules []*FormattingRule
 	Debug           bool
+
+	// Owner identifies this auto53 deployment in the
+	// ownership TXT records so that records created by
+	// other deployments (or by hand) are left untouched.
+	// Defaults to DefaultOwner.
+	Owner string
+
+	// Concurrency limits the number of concurrent
+	// requests made to each AWS service.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+
+	// Route53RequestsPerSecond limits the rate of
+	// requests made to Route53.
+	// Defaults to DefaultRoute53RequestsPerSecond.
+	Route53RequestsPerSecond int
+
+	// WaitInSync makes the execution of evaluations
+	// wait for the changes to propagate to all Route53
+	// DNS servers (INSYNC) for up to WaitTimeout
+	// (defaults to DefaultWaitTimeout).
+	WaitInSync  bool
+	WaitTimeout time.Duration
 }
 
+const (
+	DefaultWaitTimeout      = 5 * time.Minute
+	defaultWaitPollInterval = 5 * time.Second
+)
+
+var ownerRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 func NewAuto(cfg AutoConfig) (a Auto, err error) {
 	if len(cfg.FormattingRules) == 0 {
 		err = errors.Errorf("FormattingRules must be specified")
 		return
 	}
 
+	a.owner = cfg.Owner
+	if a.owner == "" {
+		a.owner = DefaultOwner
+	}
+
+	if !ownerRegexp.MatchString(a.owner) {
+		err = errors.Errorf(
+			"Owner %s must only contain alphanumeric characters, '_', '.' and '-'",
+			a.owner)
+		return
+	}
+
 	a.formattingRules = cfg.FormattingRules
+	a.rulesMtx = &sync.RWMutex{}
+	a.zoneCache = newZoneCache()
+
+	if cfg.Route53RequestsPerSecond == 0 {
+		cfg.Route53RequestsPerSecond = DefaultRoute53RequestsPerSecond
+	}
+
+	if cfg.Route53RequestsPerSecond < 0 {
+		err = errors.Errorf(
+			"Route53RequestsPerSecond must be positive - %d provided",
+			cfg.Route53RequestsPerSecond)
+		return
+	}
+
+	a.route53Limiter = newRateLimiter(cfg.Route53RequestsPerSecond)
+	a.route53MaxRetries = defaultRoute53MaxRetries
+	a.route53Backoff = defaultRoute53Backoff
+
+	a.concurrency = cfg.Concurrency
+	if a.concurrency == 0 {
+		a.concurrency = DefaultConcurrency
+	}
+
+	if a.concurrency < 0 {
+		err = errors.Errorf(
+			"Concurrency must be positive - %d provided",
+			cfg.Concurrency)
+		return
+	}
+
+	a.waitInSync = cfg.WaitInSync
+	a.waitTimeout = cfg.WaitTimeout
+	if a.waitTimeout == 0 {
+		a.waitTimeout = DefaultWaitTimeout
+	}
+	a.waitPollInterval = defaultWaitPollInterval
 	a.logger = zerolog.New(os.Stdout).
 		With().
 		Str("from", "auto").
@@ -51,6 +154,7 @@ func NewAuto(cfg AutoConfig) (a Auto, err error) {
 
 	a.route53 = route53.New(sess)
 	a.ec2 = ec2.New(sess)
+	a.autoscaling = autoscaling.New(sess)
 
 	return
 }
@@ -58,66 +162,442 @@ func NewAuto(cfg AutoConfig) (a Auto, err error) {
 const (
 	autoscalingGroupTag = "aws:autoscaling:groupName"
 	runningState        = "running"
+
+	// maxFilterValues is the maximum number of values
+	// that EC2 accepts in a single filter.
+	maxFilterValues = 200
 )
 
-// TODO paginate over all results
-func (a *Auto) GetAutoScalingGroups() (asgsMap map[string]*AutoScalingGroup, err error) {
-	var present bool
+// managedRecordTypes are the types of records that
+// auto53 lists and changes.
+var managedRecordTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"SRV":   true,
+	"PTR":   true,
+}
+
+// Reconciliation holds what has been observed and
+// computed during a single reconciliation pass.
+type Reconciliation struct {
+
+	// AutoScalingGroups is the state retrieved from
+	// EC2 for the autoscaling groups referenced by
+	// the formatting rules.
+	AutoScalingGroups map[string]*AutoScalingGroup
+
+	// Evaluations are the changes that must be
+	// applied to Route53 to reach the desired state.
+	Evaluations []*Evaluation
+
+	// Conflicts are the desired records that can't be
+	// applied because records not owned by auto53
+	// already take their names.
+	Conflicts []*Record
+
+	// Skipped are the instances left out of records
+	// for lacking the address that their rules pick.
+	Skipped []*SkippedInstance
+
+	// Results are the outcomes of executing the
+	// evaluations in each zone.
+	Results []*ZoneResult
+
+	// Targets are the names of the autoscaling groups
+	// that a targeted pass has been restricted to.
+	// Empty for full passes.
+	Targets []string
+}
+
+// FormattingRules retrieves the formatting rules that
+// passes are performed with.
+func (a *Auto) FormattingRules() (rules []*FormattingRule) {
+	a.rulesMtx.RLock()
+	defer a.rulesMtx.RUnlock()
+
+	rules = a.formattingRules
+	return
+}
+
+// SetFormattingRules replaces the formatting rules
+// that passes are performed with.
+//
+// Passes in progress finish with the previous rules.
+func (a *Auto) SetFormattingRules(rules []*FormattingRule) (err error) {
+	if len(rules) == 0 {
+		err = errors.Errorf("FormattingRules must be specified")
+		return
+	}
+
+	a.rulesMtx.Lock()
+	defer a.rulesMtx.Unlock()
+
+	a.formattingRules = rules
+	return
+}
+
+// withWaitDeadline retrieves a copy of the Auto instance
+// whose passes stop waiting for changes to get INSYNC
+// by `deadline`.
+func (a *Auto) withWaitDeadline(deadline time.Time) (b *Auto) {
+	var timeout = time.Until(deadline)
+
+	b = &Auto{}
+	*b = *a
+
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	if timeout < b.waitTimeout {
+		b.waitTimeout = timeout
+	}
+
+	return
+}
+
+// Reconcile performs a full pass of retrieving the
+// current state from EC2 and Route53, computing the
+// desired records and applying the necessary
+// evaluations.
+//
+// If `dry` is set, evaluations are computed but
+// not executed.
+func (a *Auto) Reconcile(dry bool) (res *Reconciliation, err error) {
+	res, err = a.reconcile(dry, a.FormattingRules(), true)
+	return
+}
+
+// ReconcileAutoScalingGroups performs a pass restricted
+// to the zones that records of the given autoscaling
+// groups are created in.
+//
+// Given that a zone might hold records from several
+// rules, every rule targeting those zones takes part
+// in the pass - otherwise records from the rules left
+// out would be seen as stale.
+func (a *Auto) ReconcileAutoScalingGroups(dry bool, names []string) (res *Reconciliation, err error) {
+	var (
+		zones    = map[string]bool{}
+		included = map[*FormattingRule]bool{}
+		rules    = []*FormattingRule{}
+		all      = a.FormattingRules()
+	)
+
+	for _, rule := range all {
+		err = rule.ParseGroupPattern()
+		if err != nil {
+			return
+		}
+	}
+
+	err = a.resolveZones(all)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to resolve zones")
+		return
+	}
+
+	// rules can produce records in several zones (e.g.,
+	// reverse lookup ones), so rules are pulled in until
+	// every zone of the pass is covered by all of its
+	// rules.
+	for changed := true; changed; {
+		changed = false
+
+		for _, rule := range all {
+			if included[rule] {
+				continue
+			}
+
+			if !ruleInGroups(rule, names) && !ruleInZones(rule, zones) {
+				continue
+			}
+
+			included[rule] = true
+			changed = true
+
+			for _, zone := range rule.Zones() {
+				zones[zone.ID] = true
+			}
+		}
+	}
+
+	for _, rule := range all {
+		if included[rule] {
+			rules = append(rules, rule)
+		}
+	}
+
+	if len(rules) == 0 {
+		res = &Reconciliation{
+			AutoScalingGroups: map[string]*AutoScalingGroup{},
+			Evaluations:       []*Evaluation{},
+			Targets:           names,
+		}
+		return
+	}
+
+	res, err = a.reconcile(dry, rules, false)
+	if err != nil {
+		return
+	}
+
+	res.Targets = names
+	return
+}
+
+// ruleInGroups indicates whether the rule selects the
+// instances of any of the autoscaling groups.
+func ruleInGroups(rule *FormattingRule, names []string) bool {
+	for _, name := range names {
+		if rule.MatchesGroup(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleInZones indicates whether the rule produces
+// records in any of the zones.
+func ruleInZones(rule *FormattingRule, zones map[string]bool) bool {
+	for _, zone := range rule.Zones() {
+		if zones[zone.ID] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reconcile performs a pass over the given rules.
+//
+// Unused health checks are only deleted in `full`
+// passes, given that targeted ones don't know about
+// the records of the zones left out.
+func (a *Auto) reconcile(dry bool, rules []*FormattingRule, full bool) (res *Reconciliation, err error) {
+	var (
+		currentRecords = []*Record{}
+		zonesRecords   map[string][]*Record
+		desiredRecords []*Record
+		healthChecks   []*route53.HealthCheck
+		healthChecked  = usesHealthChecks(rules)
+	)
 
-	tagsFilter := &ec2.Filter{
-		Name:   aws.String("tag:" + autoscalingGroupTag),
-		Values: []*string{},
+	res = &Reconciliation{}
+
+	res.AutoScalingGroups, err = a.getAutoScalingGroups(rules)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve autoscaling groups")
+		return
+	}
+
+	err = a.allocateOrdinals(rules, res.AutoScalingGroups, dry)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to allocate ordinals")
+		return
+	}
+
+	zonesRecords, err = a.getZonesRecords(rules)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve zones records")
+		return
+	}
+
+	for _, records := range zonesRecords {
+		currentRecords = append(currentRecords, records...)
+	}
+
+	desiredRecords, res.Skipped, err = createRecords(res.AutoScalingGroups, rules)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create desired records")
+		return
+	}
+
+	for _, instance := range res.Skipped {
+		a.logger.Warn().
+			Str("instance", instance.Instance).
+			Str("record", instance.Record).
+			Str("type", instance.Type).
+			Str("reason", instance.Reason).
+			Msg("instance left out of record")
 	}
 
+	if healthChecked {
+		healthChecks, err = a.listHealthChecks()
+		if err != nil {
+			return
+		}
+
+		err = a.assignHealthChecks(desiredRecords, healthChecks, dry)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to assign health checks")
+			return
+		}
+	}
+
+	res.Evaluations, err = GetEvaluations(currentRecords, desiredRecords)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to compute evaluations")
+		return
+	}
+
+	res.Conflicts, err = FindConflicts(currentRecords, desiredRecords)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to look for conflicts")
+		return
+	}
+
+	if dry {
+		return
+	}
+
+	res.Results, err = a.ExecuteEvaluations(res.Evaluations)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to execute evaluations")
+		return
+	}
+
+	if full && healthChecked {
+		err = a.deleteUnusedHealthChecks(desiredRecords, healthChecks)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// GetAutoScalingGroups retrieves the instances of the
+// autoscaling groups referenced by the formatting rules.
+func (a *Auto) GetAutoScalingGroups() (asgsMap map[string]*AutoScalingGroup, err error) {
+	asgsMap, err = a.getAutoScalingGroups(a.FormattingRules())
+	return
+}
+
+func (a *Auto) getAutoScalingGroups(rules []*FormattingRule) (asgsMap map[string]*AutoScalingGroup, err error) {
+	var (
+		present   bool
+		names     []string
+		selectors []*InstanceSelector
+		patterns  []*FormattingRule
+	)
+
 	asgsMap = map[string]*AutoScalingGroup{}
 
-	for _, rule := range a.formattingRules {
-		if rule.AutoScalingGroup == "" {
+	for _, rule := range rules {
+		if rule.AutoScalingGroup == "" && rule.Selector == nil {
 			err = errors.Errorf(
-				"Rule %+v does not have an autoscalinggroup specified",
+				"Rule %+v does not have an autoscalinggroup or selector specified",
 				rule)
 			return
 		}
 
-		_, present = asgsMap[rule.AutoScalingGroup]
+		err = rule.ParseGroupPattern()
+		if err != nil {
+			return
+		}
+
+		if IsPattern(rule.AutoScalingGroup) {
+			patterns = append(patterns, rule)
+			continue
+		}
+
+		_, present = asgsMap[rule.GroupName()]
 		if present {
 			continue
 		}
 
-		asgsMap[rule.AutoScalingGroup] = &AutoScalingGroup{
-			Name: rule.AutoScalingGroup,
+		asgsMap[rule.GroupName()] = &AutoScalingGroup{
+			Name: rule.GroupName(),
 		}
 
-		tagsFilter.Values = append(
-			tagsFilter.Values,
-			aws.String(rule.AutoScalingGroup))
+		if rule.AutoScalingGroup == "" {
+			selectors = append(selectors, rule.Selector)
+			continue
+		}
+
+		names = append(names, rule.AutoScalingGroup)
 	}
 
-	var (
-		input = &ec2.DescribeInstancesInput{
-			Filters: []*ec2.Filter{
-				tagsFilter,
-			},
+	// patterns are resolved against the names of all the
+	// autoscaling groups of the account.
+	if len(patterns) != 0 {
+		var groups []string
+
+		groups, err = a.listAutoScalingGroupNames()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list autoscaling groups")
+			return
 		}
-		result *ec2.DescribeInstancesOutput
-		asg    *AutoScalingGroup
-		tags   map[string]string
+
+		for _, name := range groups {
+			_, present = asgsMap[name]
+			if present || !anyRuleMatchesGroup(patterns, name) {
+				continue
+			}
+
+			asgsMap[name] = &AutoScalingGroup{
+				Name: name,
+			}
+
+			names = append(names, name)
+		}
+	}
+
+	// EC2 accepts up to 200 values per filter, so the
+	// groups are described in batches, in parallel.
+	var (
+		batches   = (len(names) + maxFilterValues - 1) / maxFilterValues
+		instances = make([][]*ec2.Instance, batches)
+		errs      = MultiError{}
+		errsMtx   sync.Mutex
 	)
 
-	result, err = a.ec2.DescribeInstances(input)
+	parallelize(batches, a.concurrency, func(batch int) {
+		var (
+			start = batch * maxFilterValues
+			end   = start + maxFilterValues
+		)
+
+		if end > len(names) {
+			end = len(names)
+		}
+
+		batchInstances, err := a.describeInstances(&ec2.Filter{
+			Name:   aws.String("tag:" + autoscalingGroupTag),
+			Values: aws.StringSlice(names[start:end]),
+		})
+		if err != nil {
+			errsMtx.Lock()
+			errs[strings.Join(names[start:end], ",")] = err
+			errsMtx.Unlock()
+			return
+		}
+
+		instances[batch] = batchInstances
+	})
+
+	if len(errs) != 0 {
+		err = errors.Wrapf(errs, "failed to describe instances")
+		return
+	}
+
+	err = a.describeSelectedInstances(selectors, asgsMap)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to describe instances")
 		return
 	}
 
-	for _, reservation := range result.Reservations {
-		for _, instance := range reservation.Instances {
-			tags = map[string]string{}
-			asg = nil
+	var (
+		asg  *AutoScalingGroup
+		tags map[string]string
+	)
 
-			for _, tag := range instance.Tags {
-				tags[*tag.Key] = *tag.Value
-			}
+	for _, batchInstances := range instances {
+		for _, instance := range batchInstances {
+			tags = instanceTags(instance)
+			asg = nil
 
 			asg, _ = asgsMap[tags[autoscalingGroupTag]]
 			if asg == nil {
@@ -127,71 +607,347 @@ func (a *Auto) GetAutoScalingGroups() (asgsMap map[string]*AutoScalingGroup, err
 				return
 			}
 
-			asg.Instances = append(asg.Instances, &Instance{
-				Id:        *instance.InstanceId,
-				PublicIp:  *instance.PublicIpAddress,
-				PrivateIp: *instance.PrivateIpAddress,
-				Tags:      tags,
-				Running:   *instance.State.Name == runningState,
-			})
+			asg.Instances = append(asg.Instances,
+				newInstance(instance, tags))
+		}
+	}
+
+	for _, asg := range asgsMap {
+		indexInstances(asg.Instances)
+	}
+
+	err = a.retrieveMembership(rules, asgsMap)
+	return
+}
+
+// indexInstances orders the instances of a group by
+// launch time (and ID), setting their Index.
+func indexInstances(instances []*Instance) {
+	sort.Slice(instances, func(i, j int) bool {
+		if !instances[i].LaunchTime.Equal(instances[j].LaunchTime) {
+			return instances[i].LaunchTime.Before(instances[j].LaunchTime)
+		}
+
+		return instances[i].Id < instances[j].Id
+	})
+
+	for i, instance := range instances {
+		instance.Index = i
+	}
+}
+
+// anyRuleMatchesGroup indicates whether any of the
+// pattern rules matches the autoscaling group `name`.
+func anyRuleMatchesGroup(rules []*FormattingRule, name string) bool {
+	for _, rule := range rules {
+		if rule.MatchesGroup(name) {
+			return true
 		}
 	}
 
+	return false
+}
+
+// listAutoScalingGroupNames retrieves the names of all
+// the autoscaling groups, going through all the pages.
+func (a *Auto) listAutoScalingGroupNames() (names []string, err error) {
+	var (
+		input  = &autoscaling.DescribeAutoScalingGroupsInput{}
+		result *autoscaling.DescribeAutoScalingGroupsOutput
+	)
+
+	for {
+		result, err = a.autoscaling.DescribeAutoScalingGroups(input)
+		if err != nil {
+			return
+		}
+
+		for _, group := range result.AutoScalingGroups {
+			names = append(names, aws.StringValue(group.AutoScalingGroupName))
+		}
+
+		if aws.StringValue(result.NextToken) == "" {
+			return
+		}
+
+		input.NextToken = result.NextToken
+	}
+}
+
+// describeSelectedInstances fills the groups of the
+// selectors of rules without an autoscaling group with
+// the instances that each of them selects.
+func (a *Auto) describeSelectedInstances(selectors []*InstanceSelector, asgsMap map[string]*AutoScalingGroup) (err error) {
+	var (
+		errs = MultiError{}
+		mtx  sync.Mutex
+	)
+
+	parallelize(len(selectors), a.concurrency, func(job int) {
+		var selector = selectors[job]
+
+		instances, err := a.describeInstances(selector.Filters()...)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if err != nil {
+			errs[selector.String()] = err
+			return
+		}
+
+		group := asgsMap[selector.String()]
+		for _, instance := range instances {
+			group.Instances = append(group.Instances,
+				newInstance(instance, instanceTags(instance)))
+		}
+	})
+
+	if len(errs) != 0 {
+		err = errors.Wrapf(errs, "failed to describe selected instances")
+		return
+	}
+
+	return
+}
+
+// instanceTags indexes the tags of an EC2 instance by
+// their keys.
+func instanceTags(instance *ec2.Instance) (tags map[string]string) {
+	tags = map[string]string{}
+
+	for _, tag := range instance.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return
+}
+
+// newInstance converts an EC2 instance into an Instance,
+// leaving empty the addresses that it doesn't have (e.g.,
+// instances without a public IP or that are stopped).
+func newInstance(instance *ec2.Instance, tags map[string]string) (i *Instance) {
+	i = &Instance{
+		Id:        aws.StringValue(instance.InstanceId),
+		PublicIp:  aws.StringValue(instance.PublicIpAddress),
+		PrivateIp: aws.StringValue(instance.PrivateIpAddress),
+		Tags:      tags,
+
+		PrivateDnsName: aws.StringValue(instance.PrivateDnsName),
+		PublicDnsName:  aws.StringValue(instance.PublicDnsName),
+
+		AutoScalingGroup: tags[autoscalingGroupTag],
+		Ordinal:          parseOrdinal(tags),
+		InstanceType:     aws.StringValue(instance.InstanceType),
+		LaunchTime:       aws.TimeValue(instance.LaunchTime),
+
+		SubnetId:  aws.StringValue(instance.SubnetId),
+		VpcId:     aws.StringValue(instance.VpcId),
+		Lifecycle: aws.StringValue(instance.InstanceLifecycle),
+	}
+
+	if instance.Placement != nil {
+		i.AvailabilityZone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+
+	// EC2 only sets the lifecycle of spot and scheduled
+	// instances.
+	if i.Lifecycle == "" {
+		i.Lifecycle = LifecycleOnDemand
+	}
+
+	if instance.State != nil {
+		i.Running = aws.StringValue(instance.State.Name) == runningState
+	}
+
+	for _, networkInterface := range instance.NetworkInterfaces {
+		if networkInterface.Association != nil &&
+			aws.StringValue(networkInterface.Association.IpOwnerId) != "amazon" &&
+			aws.StringValue(networkInterface.Association.PublicIp) == i.PublicIp {
+			i.ElasticIp = i.PublicIp
+		}
+
+		if networkInterface.Attachment == nil {
+			continue
+		}
+
+		var ipv6Ip string
+		if len(networkInterface.Ipv6Addresses) != 0 {
+			ipv6Ip = aws.StringValue(networkInterface.Ipv6Addresses[0].Ipv6Address)
+		}
+
+		switch aws.Int64Value(networkInterface.Attachment.DeviceIndex) {
+		case 0:
+			i.Ipv6Ip = ipv6Ip
+		case 1:
+			i.SecondaryIp = aws.StringValue(networkInterface.PrivateIpAddress)
+			i.SecondaryIpv6Ip = ipv6Ip
+		}
+	}
+
+	return
+}
+
+// describeInstances retrieves all the instances that
+// match the filters, going through all the pages.
+func (a *Auto) describeInstances(filters ...*ec2.Filter) (instances []*ec2.Instance, err error) {
+	var (
+		input = &ec2.DescribeInstancesInput{
+			Filters: filters,
+		}
+		result *ec2.DescribeInstancesOutput
+	)
+
+	for {
+		result, err = a.ec2.DescribeInstances(input)
+		if err != nil {
+			return
+		}
+
+		for _, reservation := range result.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+
+		if result.NextToken == nil || *result.NextToken == "" {
+			break
+		}
+
+		input.NextToken = result.NextToken
+	}
+
 	return
 }
 
 // GetZonesRecords returns a map of zoneIDs and
 // A records associated with each zone.
-// TODO make this retrieval parallel.
+//
+// Zones are retrieved in parallel, and failures are
+// reported for every zone that failed.
 func (a *Auto) GetZonesRecords() (recordsMap map[string][]*Record, err error) {
+	recordsMap, err = a.getZonesRecords(a.FormattingRules())
+	return
+}
+
+func (a *Auto) getZonesRecords(rules []*FormattingRule) (recordsMap map[string][]*Record, err error) {
 	var (
 		present bool
-		records []*Record
+		zones   []string
+		errs    = MultiError{}
+		mtx     sync.Mutex
 	)
 
 	recordsMap = map[string][]*Record{}
 
-	for _, rule := range a.formattingRules {
-		if rule.Zone.ID == "" {
-			err = errors.Errorf(
-				"Rule %+v does not have a zone specified",
-				rule)
-			return
-		}
+	err = a.resolveZones(rules)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to resolve zones")
+		return
+	}
 
-		_, present = recordsMap[rule.Zone.ID]
-		if present {
-			continue
+	for _, rule := range rules {
+		for _, zone := range rule.Zones() {
+			_, present = recordsMap[zone.ID]
+			if present {
+				continue
+			}
+
+			recordsMap[zone.ID] = nil
+			zones = append(zones, zone.ID)
 		}
+	}
+
+	parallelize(len(zones), a.concurrency, func(job int) {
+		var zone = zones[job]
+
+		records, err := a.ListZoneRecords(zone)
+
+		mtx.Lock()
+		defer mtx.Unlock()
 
-		records, err = a.ListZoneRecords(rule.Zone.ID)
 		if err != nil {
-			err = errors.Wrapf(err,
-				"failed to retrieve records from zone %s",
-				rule.Zone)
+			errs[zone] = err
 			return
 		}
 
-		recordsMap[rule.Zone.ID] = records
+		recordsMap[zone] = records
+	})
+
+	if len(errs) != 0 {
+		err = errors.Wrapf(errs,
+			"failed to retrieve records from %d out of %d zones",
+			len(errs), len(zones))
+		return
 	}
 
 	return
 }
 
-// TODO honor route53 rate limits
-func (a *Auto) ExecuteEvaluations(evals []*Evaluation) (err error) {
+// ZoneResult is the outcome of executing the
+// evaluations that target a single zone.
+type ZoneResult struct {
+
+	// Zone is the ID of the zone.
+	Zone string
+
+	// Evaluations is the number of evaluations
+	// targeting the zone.
+	Evaluations int
+
+	// Changes are the change batches submitted
+	// successfully.
+	Changes []*Change
+
+	// Error is the message of the error that
+	// interrupted the changes to the zone, if any.
+	Error string
+}
+
+// Change tracks the propagation of a change batch
+// submitted to Route53.
+type Change struct {
+
+	// ID identifies the change in Route53.
+	ID string
+
+	// Status is either PENDING or INSYNC.
+	Status string
+
+	// Submitted is the time at which the change has
+	// been submitted.
+	Submitted time.Time
+
+	// Duration is how long it took for the change to
+	// get INSYNC since it has been submitted - only
+	// known when waiting for changes.
+	Duration time.Duration
+}
+
+// ExecuteEvaluations applies the evaluations to Route53
+// zone by zone.
+//
+// The changes of each zone are split into batches that
+// respect the limits of Route53, submitted respecting the
+// rate limit and retried with backoff when throttled.
+//
+// A zone that fails doesn't prevent the others from
+// being changed: every zone gets a result and `err`
+// summarizes the failures.
+//
+// If configured to wait, changes that don't get INSYNC
+// in time fail their zones.
+func (a *Auto) ExecuteEvaluations(evals []*Evaluation) (results []*ZoneResult, err error) {
 	var (
 		evalsMap = map[string][]*Evaluation{}
-		inputs   []*route53.ChangeResourceRecordSetsInput
-		action   string
+		zones    = []string{}
+		failures = []string{}
 		present  bool
-		ndx      int
 	)
 
 	for _, eval := range evals {
 		_, present = evalsMap[eval.Record.Zone.ID]
 		if !present {
 			evalsMap[eval.Record.Zone.ID] = make([]*Evaluation, 0)
+			zones = append(zones, eval.Record.Zone.ID)
 		}
 
 		evalsMap[eval.Record.Zone.ID] = append(
@@ -199,87 +955,290 @@ func (a *Auto) ExecuteEvaluations(evals []*Evaluation) (err error) {
 			eval)
 	}
 
-	inputs = make([]*route53.ChangeResourceRecordSetsInput, len(evalsMap))
-	for zone, zoneEvals := range evalsMap {
-		changes := make([]*route53.Change, 0)
+	results = make([]*ZoneResult, 0, len(zones))
+	for _, zone := range zones {
+		results = append(results,
+			a.executeZoneEvaluations(zone, evalsMap[zone]))
+	}
 
-		for _, eval := range zoneEvals {
-			switch eval.Type {
-			case EvaluationAddRecord:
-				action = "CREATE"
-			case EvaluationRemoveRecord:
-				action = "DELETE"
-			default:
-				err = errors.Errorf("Unexpected evaluation type %+v", eval)
-				return
-			}
+	if a.waitInSync {
+		a.waitChanges(results)
+	}
 
-			resourceRecords := make([]*route53.ResourceRecord, 0)
+	for _, result := range results {
+		if result.Error != "" {
+			failures = append(failures, result.Zone+": "+result.Error)
+		}
+	}
 
-			for _, ip := range eval.Record.IPs {
-				resourceRecords = append(
-					resourceRecords,
-					&route53.ResourceRecord{
-						Value: aws.String(ip),
-					})
-			}
+	if len(failures) != 0 {
+		err = errors.Errorf(
+			"failed to change %d out of %d zones - %s",
+			len(failures), len(zones),
+			strings.Join(failures, "; "))
+		return
+	}
 
-			changes = append(changes, &route53.Change{
-				Action: aws.String(action),
-				ResourceRecordSet: &route53.ResourceRecordSet{
-					Name:            aws.String(eval.Record.Name + "." + eval.Record.Zone.Name + "."),
-					Type:            aws.String("A"),
-					ResourceRecords: resourceRecords,
-					TTL:             aws.Int64(300),
-				},
-			})
+	return
+}
+
+func (a *Auto) executeZoneEvaluations(zone string, evals []*Evaluation) (result *ZoneResult) {
+	var groups = make([][]*route53.Change, 0, len(evals))
+
+	result = &ZoneResult{
+		Zone:        zone,
+		Evaluations: len(evals),
+	}
+
+	for _, eval := range evals {
+		changes, err := a.evaluationChanges(eval)
+		if err != nil {
+			result.Error = err.Error()
+			return
 		}
 
-		inputs[ndx] = &route53.ChangeResourceRecordSetsInput{
+		groups = append(groups, changes)
+	}
+
+	for _, batch := range batchChanges(groups) {
+		input := &route53.ChangeResourceRecordSetsInput{
 			ChangeBatch: &route53.ChangeBatch{
-				Changes: changes,
+				Changes: batch,
 				Comment: aws.String("auto53"),
 			},
 			HostedZoneId: aws.String(zone),
 		}
 
-		ndx++
-	}
+		var output *route53.ChangeResourceRecordSetsOutput
 
-	for _, input := range inputs {
-		_, err = a.route53.ChangeResourceRecordSets(input)
+		err := a.callRoute53(func() (err error) {
+			output, err = a.route53.ChangeResourceRecordSets(input)
+			return
+		})
 		if err != nil {
-			err = errors.Wrapf(err, "batch request failed %+v", input)
+			result.Error = errors.Wrapf(err,
+				"batch request failed %+v", input).Error()
 			return
 		}
+
+		result.Changes = append(result.Changes, &Change{
+			ID:        *output.ChangeInfo.Id,
+			Status:    *output.ChangeInfo.Status,
+			Submitted: time.Now(),
+		})
 	}
 
 	return
 }
 
-// ListZoneRecords lists the A records of a given zone
-// identified by a ZoneID.
-// TODO paginate over all results
+// waitChanges polls the changes of the zones that have
+// been changed successfully until they're all INSYNC or
+// the timeout is reached, failing the zones with changes
+// that didn't make it.
+func (a *Auto) waitChanges(results []*ZoneResult) {
+	var deadline = time.Now().Add(a.waitTimeout)
+
+	for {
+		pending := 0
+
+		for _, result := range results {
+			if result.Error != "" {
+				continue
+			}
+
+			for _, change := range result.Changes {
+				if change.Status == route53.ChangeStatusInsync {
+					continue
+				}
+
+				var output *route53.GetChangeOutput
+
+				err := a.callRoute53(func() (err error) {
+					output, err = a.route53.GetChange(&route53.GetChangeInput{
+						Id: aws.String(change.ID),
+					})
+					return
+				})
+				if err != nil {
+					result.Error = errors.Wrapf(err,
+						"failed to retrieve status of change %s",
+						change.ID).Error()
+					break
+				}
+
+				change.Status = *output.ChangeInfo.Status
+				if change.Status == route53.ChangeStatusInsync {
+					change.Duration = time.Since(change.Submitted)
+					continue
+				}
+
+				pending++
+			}
+		}
+
+		if pending == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(a.waitPollInterval)
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+
+		for _, change := range result.Changes {
+			if change.Status != route53.ChangeStatusInsync {
+				result.Error = errors.Errorf(
+					"change %s not INSYNC after %s",
+					change.ID, a.waitTimeout).Error()
+				break
+			}
+		}
+	}
+}
+
+// evaluationChanges creates the Route53 changes that
+// carry out an evaluation.
+func (a *Auto) evaluationChanges(eval *Evaluation) (changes []*route53.Change, err error) {
+	var action string
+
+	if eval.Type == EvaluationRemoveRecord && !eval.Record.Owned ||
+		eval.Type == EvaluationUpdateRecord &&
+			(eval.Previous == nil || !eval.Previous.Owned) {
+		err = errors.Errorf(
+			"refusing to modify record not owned by auto53 %+v",
+			eval.Record)
+		return
+	}
+
+	switch eval.Type {
+	case EvaluationAddRecord:
+		action = "CREATE"
+	case EvaluationUpdateRecord:
+		action = "UPSERT"
+	case EvaluationRemoveRecord:
+		action = "DELETE"
+	default:
+		err = errors.Errorf("Unexpected evaluation type %+v", eval)
+		return
+	}
+
+	resourceRecords := make([]*route53.ResourceRecord, 0)
+
+	for _, value := range eval.Record.Values {
+		resourceRecords = append(
+			resourceRecords,
+			&route53.ResourceRecord{
+				Value: aws.String(value),
+			})
+	}
+
+	// records being updated are already owned, while
+	// records being created might have been left with
+	// their ownership record (e.g., deleted by hand) -
+	// the whole batch is rejected if the record itself
+	// still exists, so overwriting it is safe.
+	switch eval.Type {
+	case EvaluationAddRecord:
+		changes = append(changes,
+			ownershipChange(route53.ChangeActionUpsert, a.owner, eval.Record))
+	case EvaluationRemoveRecord:
+		changes = append(changes,
+			ownershipChange(action, a.owner, eval.Record))
+	}
+
+	recordSet := &route53.ResourceRecordSet{
+		Name:            aws.String(eval.Record.Name + "." + eval.Record.Zone.Name + "."),
+		Type:            aws.String(eval.Record.Type),
+		ResourceRecords: resourceRecords,
+		TTL:             aws.Int64(eval.Record.TTL),
+		Weight:          eval.Record.Weight,
+	}
+
+	// alias record sets take the TTL of their target.
+	if eval.Record.Alias != nil {
+		recordSet.ResourceRecords = nil
+		recordSet.TTL = nil
+		recordSet.AliasTarget = &route53.AliasTarget{
+			DNSName:              aws.String(eval.Record.Alias.DNSName),
+			HostedZoneId:         aws.String(eval.Record.Alias.HostedZoneId),
+			EvaluateTargetHealth: aws.Bool(eval.Record.Alias.EvaluateTargetHealth),
+		}
+	}
+
+	if eval.Record.SetIdentifier != "" {
+		recordSet.SetIdentifier = aws.String(eval.Record.SetIdentifier)
+	}
+
+	if eval.Record.Region != "" {
+		recordSet.Region = aws.String(eval.Record.Region)
+	}
+
+	if eval.Record.Failover != "" {
+		recordSet.Failover = aws.String(eval.Record.Failover)
+	}
+
+	if eval.Record.MultiValueAnswer {
+		recordSet.MultiValueAnswer = aws.Bool(true)
+	}
+
+	if eval.Record.HealthCheckId != "" {
+		recordSet.HealthCheckId = aws.String(eval.Record.HealthCheckId)
+	}
+
+	changes = append(changes, &route53.Change{
+		Action:            aws.String(action),
+		ResourceRecordSet: recordSet,
+	})
+
+	return
+}
+
+// ListZoneRecords lists the managed records of a zone
+// identified by a ZoneID, marking as owned those that
+// have a companion ownership TXT record of this owner.
 func (a *Auto) ListZoneRecords(zone string) (records []*Record, err error) {
 	var (
 		input = &route53.ListResourceRecordSetsInput{
 			HostedZoneId: aws.String(zone),
 		}
-		result   *route53.ListResourceRecordSetsOutput
-		zoneName string
+		result     *route53.ListResourceRecordSetsOutput
+		recordSets []*route53.ResourceRecordSet
+		zoneName   string
 	)
 
-	result, err = a.route53.ListResourceRecordSets(input)
-	if err != nil {
-		err = errors.Wrapf(err,
-			"failed to list resource records of zone %s",
-			zone)
-		return
+	for {
+		err = a.callRoute53(func() (err error) {
+			result, err = a.route53.ListResourceRecordSets(input)
+			return
+		})
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to list resource records of zone %s",
+				zone)
+			return
+		}
+
+		recordSets = append(recordSets, result.ResourceRecordSets...)
+
+		if result.IsTruncated == nil || !*result.IsTruncated {
+			break
+		}
+
+		input.StartRecordName = result.NextRecordName
+		input.StartRecordType = result.NextRecordType
+		input.StartRecordIdentifier = result.NextRecordIdentifier
 	}
 
 	records = make([]*Record, 0)
 
-	for _, recordSet := range result.ResourceRecordSets {
+	for _, recordSet := range recordSets {
 		if *recordSet.Type == "SOA" {
 			zoneName = "." + *recordSet.Name
 			break
@@ -293,8 +1252,8 @@ func (a *Auto) ListZoneRecords(zone string) (records []*Record, err error) {
 		return
 	}
 
-	for _, recordSet := range result.ResourceRecordSets {
-		if *recordSet.Type != "A" {
+	for _, recordSet := range recordSets {
+		if !managedRecordTypes[*recordSet.Type] {
 			continue
 		}
 
@@ -303,16 +1262,60 @@ func (a *Auto) ListZoneRecords(zone string) (records []*Record, err error) {
 				ID:   zone,
 				Name: strings.Trim(zoneName, "."),
 			},
-			Name: strings.TrimSuffix(*recordSet.Name, zoneName),
-			IPs:  []string{},
+			Name:             decodeDNSName(strings.TrimSuffix(*recordSet.Name, zoneName)),
+			Type:             *recordSet.Type,
+			TTL:              aws.Int64Value(recordSet.TTL),
+			Values:           []string{},
+			SetIdentifier:    aws.StringValue(recordSet.SetIdentifier),
+			Weight:           recordSet.Weight,
+			Region:           aws.StringValue(recordSet.Region),
+			Failover:         aws.StringValue(recordSet.Failover),
+			MultiValueAnswer: aws.BoolValue(recordSet.MultiValueAnswer),
+			HealthCheckId:    aws.StringValue(recordSet.HealthCheckId),
 		}
 
 		for _, resourceRecord := range recordSet.ResourceRecords {
-			record.IPs = append(record.IPs, *resourceRecord.Value)
+			record.Values = append(record.Values, *resourceRecord.Value)
+		}
+
+		if recordSet.AliasTarget != nil {
+			record.Alias = &AliasTarget{
+				DNSName: strings.ToLower(strings.TrimSuffix(
+					aws.StringValue(recordSet.AliasTarget.DNSName), ".")) + ".",
+				HostedZoneId:         aws.StringValue(recordSet.AliasTarget.HostedZoneId),
+				EvaluateTargetHealth: aws.BoolValue(recordSet.AliasTarget.EvaluateTargetHealth),
+			}
 		}
 
 		records = append(records, record)
 	}
 
+	var (
+		owned      = map[string]bool{}
+		ownerValue = ownershipValue(a.owner)
+	)
+
+	for _, recordSet := range recordSets {
+		if *recordSet.Type != "TXT" {
+			continue
+		}
+
+		name, recordType, ok := parseOwnershipRecordName(
+			strings.TrimSuffix(*recordSet.Name, zoneName))
+		if !ok || !managedRecordTypes[recordType] {
+			continue
+		}
+
+		for _, resourceRecord := range recordSet.ResourceRecords {
+			if *resourceRecord.Value == ownerValue {
+				owned[name+"/"+recordType+"/"+aws.StringValue(recordSet.SetIdentifier)] = true
+			}
+		}
+	}
+
+	for _, record := range records {
+		record.Owned = owned[record.Name+"/"+record.Type+"/"+record.SetIdentifier]
+	}
+
 	return
 }
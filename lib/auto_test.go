@@ -0,0 +1,1033 @@
+package lib
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEC2 serves DescribeInstances from a list of
+// pages, chaining them with NextToken.
+type fakeEC2 struct {
+	ec2iface.EC2API
+
+	pages   [][]*ec2.Instance
+	calls   int
+	healthy map[string]bool
+
+	// tags are the tags created by CreateTags, indexed
+	// by instance ID and key.
+	tags map[string]map[string]string
+	mtx  sync.Mutex
+}
+
+func (f *fakeEC2) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.tags == nil {
+		f.tags = map[string]map[string]string{}
+	}
+
+	for _, id := range input.Resources {
+		if f.tags[*id] == nil {
+			f.tags[*id] = map[string]string{}
+		}
+
+		for _, tag := range input.Tags {
+			f.tags[*id][*tag.Key] = *tag.Value
+		}
+	}
+
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	var page int
+
+	f.calls++
+
+	if input.NextToken != nil {
+		page, _ = strconv.Atoi(*input.NextToken)
+	}
+
+	output := &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{
+			{Instances: f.pages[page]},
+		},
+	}
+
+	if page+1 < len(f.pages) {
+		output.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+
+	return output, nil
+}
+
+func (f *fakeEC2) DescribeInstanceStatus(input *ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+	output := &ec2.DescribeInstanceStatusOutput{}
+
+	for _, id := range input.InstanceIds {
+		status := aws.String("impaired")
+		if f.healthy[*id] {
+			status = aws.String(instanceStatusOk)
+		}
+
+		output.InstanceStatuses = append(output.InstanceStatuses, &ec2.InstanceStatus{
+			InstanceId:     id,
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: status},
+			SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(instanceStatusOk)},
+		})
+	}
+
+	return output, nil
+}
+
+// fakeAutoScaling serves DescribeAutoScalingGroups from
+// the lifecycle states of the instances of each group,
+// describing all of them when no names are given.
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+
+	states map[string]map[string]string
+	calls  int
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+
+	f.calls++
+
+	names := input.AutoScalingGroupNames
+	if len(names) == 0 {
+		for name := range f.states {
+			names = append(names, aws.String(name))
+		}
+	}
+
+	for _, name := range names {
+		group := &autoscaling.Group{AutoScalingGroupName: name}
+
+		for id, state := range f.states[*name] {
+			group.Instances = append(group.Instances, &autoscaling.Instance{
+				InstanceId:     aws.String(id),
+				LifecycleState: aws.String(state),
+			})
+		}
+
+		output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+	}
+
+	return output, nil
+}
+
+// fakeRoute53 serves ListResourceRecordSets from a list
+// of record sets, `pageSize` at a time.
+type fakeRoute53 struct {
+	route53iface.Route53API
+
+	recordSets []*route53.ResourceRecordSet
+	pageSize   int
+	calls      int
+	listErrors map[string]error
+	mtx        sync.Mutex
+
+	// changeErrors are returned, in order, by calls
+	// to ChangeResourceRecordSets on each zone.
+	changeErrors map[string][]error
+	changes      []*route53.ChangeResourceRecordSetsInput
+
+	// pendingPolls is the number of times that each
+	// change is reported as PENDING before INSYNC.
+	pendingPolls int
+	polls        map[string]int
+
+	// healthChecks are served by ListHealthChecks and
+	// grow with CreateHealthCheck.
+	healthChecks        []*route53.HealthCheck
+	deletedHealthChecks []string
+
+	// hostedZones are served, sorted by name, by
+	// ListHostedZonesByName, and the VPCs of each of
+	// them by GetHostedZone.
+	hostedZones     []*route53.HostedZone
+	hostedZoneVPCs  map[string][]string
+	hostedZoneCalls int
+}
+
+func (f *fakeRoute53) ListHostedZonesByName(input *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error) {
+	output := &route53.ListHostedZonesByNameOutput{IsTruncated: aws.Bool(false)}
+
+	f.hostedZoneCalls++
+
+	for _, hostedZone := range f.hostedZones {
+		if *hostedZone.Name >= *input.DNSName {
+			output.HostedZones = append(output.HostedZones, hostedZone)
+		}
+	}
+
+	return output, nil
+}
+
+func (f *fakeRoute53) GetHostedZone(input *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	output := &route53.GetHostedZoneOutput{}
+
+	for _, vpc := range f.hostedZoneVPCs[*input.Id] {
+		output.VPCs = append(output.VPCs, &route53.VPC{VPCId: aws.String(vpc)})
+	}
+
+	return output, nil
+}
+
+func (f *fakeRoute53) ListHealthChecks(input *route53.ListHealthChecksInput) (*route53.ListHealthChecksOutput, error) {
+	return &route53.ListHealthChecksOutput{
+		HealthChecks: f.healthChecks,
+		IsTruncated:  aws.Bool(false),
+	}, nil
+}
+
+func (f *fakeRoute53) CreateHealthCheck(input *route53.CreateHealthCheckInput) (*route53.CreateHealthCheckOutput, error) {
+	check := &route53.HealthCheck{
+		Id:                aws.String("hc-" + strconv.Itoa(len(f.healthChecks)+1)),
+		CallerReference:   input.CallerReference,
+		HealthCheckConfig: input.HealthCheckConfig,
+	}
+
+	f.healthChecks = append(f.healthChecks, check)
+	return &route53.CreateHealthCheckOutput{HealthCheck: check}, nil
+}
+
+func (f *fakeRoute53) DeleteHealthCheck(input *route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error) {
+	f.deletedHealthChecks = append(f.deletedHealthChecks, *input.HealthCheckId)
+	return &route53.DeleteHealthCheckOutput{}, nil
+}
+
+func (f *fakeRoute53) GetChange(input *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	var status = route53.ChangeStatusInsync
+
+	if f.polls == nil {
+		f.polls = map[string]int{}
+	}
+
+	f.polls[*input.Id]++
+	if f.polls[*input.Id] <= f.pendingPolls {
+		status = route53.ChangeStatusPending
+	}
+
+	return &route53.GetChangeOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id:     input.Id,
+			Status: aws.String(status),
+		},
+	}, nil
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	var zone = *input.HostedZoneId
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if len(f.changeErrors[zone]) != 0 {
+		err := f.changeErrors[zone][0]
+		f.changeErrors[zone] = f.changeErrors[zone][1:]
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// like Route53, reject the whole batch if a record
+	// set being created already exists.
+	for _, change := range input.ChangeBatch.Changes {
+		if *change.Action != route53.ChangeActionCreate {
+			continue
+		}
+
+		for _, recordSet := range f.recordSets {
+			if *recordSet.Name == *change.ResourceRecordSet.Name &&
+				*recordSet.Type == *change.ResourceRecordSet.Type &&
+				aws.StringValue(recordSet.SetIdentifier) ==
+					aws.StringValue(change.ResourceRecordSet.SetIdentifier) {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch,
+					"record set "+*recordSet.Name+" already exists", nil)
+			}
+		}
+	}
+
+	f.changes = append(f.changes, input)
+	return &route53.ChangeResourceRecordSetsOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id:     aws.String("/change/" + strconv.Itoa(len(f.changes))),
+			Status: aws.String(route53.ChangeStatusPending),
+		},
+	}, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	var start int
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.calls++
+
+	if f.listErrors[*input.HostedZoneId] != nil {
+		return nil, f.listErrors[*input.HostedZoneId]
+	}
+
+	if f.pageSize == 0 {
+		f.pageSize = 100
+	}
+
+	if input.StartRecordName != nil {
+		for start = range f.recordSets {
+			if *f.recordSets[start].Name == *input.StartRecordName &&
+				*f.recordSets[start].Type == *input.StartRecordType {
+				break
+			}
+		}
+	}
+
+	end := start + f.pageSize
+	if end >= len(f.recordSets) {
+		return &route53.ListResourceRecordSetsOutput{
+			ResourceRecordSets: f.recordSets[start:],
+			IsTruncated:        aws.Bool(false),
+		}, nil
+	}
+
+	return &route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets: f.recordSets[start:end],
+		IsTruncated:        aws.Bool(true),
+		NextRecordName:     f.recordSets[end].Name,
+		NextRecordType:     f.recordSets[end].Type,
+	}, nil
+}
+
+func newTestAuto(rules []*FormattingRule) *Auto {
+	return &Auto{
+		logger:          zerolog.Nop(),
+		owner:           DefaultOwner,
+		formattingRules: rules,
+		rulesMtx:        &sync.RWMutex{},
+	}
+}
+
+func newTestInstance(id, asg, ip string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:       aws.String(id),
+		PrivateIpAddress: aws.String(ip),
+		PublicIpAddress:  aws.String(ip),
+		State:            &ec2.InstanceState{Name: aws.String("running")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(autoscalingGroupTag), Value: aws.String(asg)},
+		},
+	}
+}
+
+func newTestRecordSet(name, recordType string, values ...string) *route53.ResourceRecordSet {
+	recordSet := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String(recordType),
+		TTL:  aws.Int64(300),
+	}
+
+	for _, value := range values {
+		recordSet.ResourceRecords = append(recordSet.ResourceRecords,
+			&route53.ResourceRecord{Value: aws.String(value)})
+	}
+
+	return recordSet
+}
+
+func TestGetAutoScalingGroupsPaginates(t *testing.T) {
+	ec2Client := &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{
+				newTestInstance("i-1", "asg1", "10.0.0.1"),
+				newTestInstance("i-2", "asg2", "10.0.0.2"),
+			},
+			{
+				newTestInstance("i-3", "asg1", "10.0.0.3"),
+			},
+			{
+				newTestInstance("i-4", "asg2", "10.0.0.4"),
+			},
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "asg1"},
+		{AutoScalingGroup: "asg2"},
+	})
+	a.ec2 = ec2Client
+
+	asgs, err := a.GetAutoScalingGroups()
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, ec2Client.calls)
+	require.Len(t, asgs["asg1"].Instances, 2)
+	require.Len(t, asgs["asg2"].Instances, 2)
+	assert.Equal(t, "i-3", asgs["asg1"].Instances[1].Id)
+	assert.Equal(t, "i-4", asgs["asg2"].Instances[1].Id)
+}
+
+func TestGetAutoScalingGroupsRetrievesMembership(t *testing.T) {
+	ec2Client := &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{
+				newTestInstance("i-1", "asg1", "10.0.0.1"),
+				newTestInstance("i-2", "asg1", "10.0.0.2"),
+				newTestInstance("i-3", "asg2", "10.0.0.3"),
+			},
+		},
+		healthy: map[string]bool{"i-1": true},
+	}
+	autoscalingClient := &fakeAutoScaling{
+		states: map[string]map[string]string{
+			"asg1": {"i-1": "InService", "i-2": "Terminating:Wait"},
+			"asg2": {"i-3": "InService"},
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "asg1", Membership: MembershipHealthy},
+		{AutoScalingGroup: "asg2"},
+	})
+	a.ec2 = ec2Client
+	a.autoscaling = autoscalingClient
+
+	asgs, err := a.GetAutoScalingGroups()
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, autoscalingClient.calls)
+
+	require.Len(t, asgs["asg1"].Instances, 2)
+	assert.Equal(t, "InService", asgs["asg1"].Instances[0].LifecycleState)
+	assert.True(t, asgs["asg1"].Instances[0].Healthy)
+	assert.Equal(t, "Terminating:Wait", asgs["asg1"].Instances[1].LifecycleState)
+	assert.False(t, asgs["asg1"].Instances[1].Healthy)
+
+	// asg2's rule doesn't require membership details.
+	require.Len(t, asgs["asg2"].Instances, 1)
+	assert.Empty(t, asgs["asg2"].Instances[0].LifecycleState)
+}
+
+func TestGetAutoScalingGroupsResolvesPatterns(t *testing.T) {
+	ec2Client := &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{
+				newTestInstance("i-1", "api-20260912-blue", "10.0.0.1"),
+				newTestInstance("i-2", "api-20260913-green", "10.0.0.2"),
+			},
+		},
+	}
+	autoscalingClient := &fakeAutoScaling{
+		states: map[string]map[string]string{
+			"api-20260912-blue":  {"i-1": "InService"},
+			"api-20260913-green": {"i-2": "InService"},
+			"workers":            {},
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "api-*"},
+	})
+	a.ec2 = ec2Client
+	a.autoscaling = autoscalingClient
+
+	asgs, err := a.GetAutoScalingGroups()
+	require.NoError(t, err)
+
+	require.Len(t, asgs, 2)
+	require.Len(t, asgs["api-20260912-blue"].Instances, 1)
+	assert.Equal(t, "api-20260912-blue",
+		asgs["api-20260912-blue"].Instances[0].AutoScalingGroup)
+	require.Len(t, asgs["api-20260913-green"].Instances, 1)
+}
+
+func TestNewInstance(t *testing.T) {
+	var testCases = []struct {
+		desc     string
+		instance *ec2.Instance
+		expected *Instance
+	}{
+		{
+			desc: "stopped instance without addresses",
+			instance: &ec2.Instance{
+				InstanceId: aws.String("i-1"),
+				State:      &ec2.InstanceState{Name: aws.String("stopped")},
+			},
+			expected: &Instance{Id: "i-1", Lifecycle: "on-demand"},
+		},
+		{
+			desc: "spot instance placement",
+			instance: &ec2.Instance{
+				InstanceId:        aws.String("i-1"),
+				InstanceLifecycle: aws.String("spot"),
+				Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				SubnetId:          aws.String("subnet-1"),
+				VpcId:             aws.String("vpc-1"),
+			},
+			expected: &Instance{
+				Id:               "i-1",
+				AvailabilityZone: "us-east-1a",
+				SubnetId:         "subnet-1",
+				VpcId:            "vpc-1",
+				Lifecycle:        "spot",
+			},
+		},
+		{
+			desc: "amazon provided public address",
+			instance: &ec2.Instance{
+				InstanceId:       aws.String("i-1"),
+				PrivateIpAddress: aws.String("10.0.0.1"),
+				PublicIpAddress:  aws.String("1.1.1.1"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{
+						Association: &ec2.InstanceNetworkInterfaceAssociation{
+							IpOwnerId: aws.String("amazon"),
+							PublicIp:  aws.String("1.1.1.1"),
+						},
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.0.1"),
+					},
+				},
+			},
+			expected: &Instance{
+				Id:        "i-1",
+				PrivateIp: "10.0.0.1",
+				PublicIp:  "1.1.1.1",
+				Lifecycle: "on-demand",
+			},
+		},
+		{
+			desc: "elastic ip and secondary interface",
+			instance: &ec2.Instance{
+				InstanceId:       aws.String("i-1"),
+				PrivateIpAddress: aws.String("10.0.0.1"),
+				PublicIpAddress:  aws.String("2.2.2.2"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{
+						Association: &ec2.InstanceNetworkInterfaceAssociation{
+							IpOwnerId: aws.String("123456789012"),
+							PublicIp:  aws.String("2.2.2.2"),
+						},
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.0.1"),
+					},
+					{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+						PrivateIpAddress: aws.String("10.0.1.1"),
+					},
+				},
+			},
+			expected: &Instance{
+				Id:          "i-1",
+				PrivateIp:   "10.0.0.1",
+				PublicIp:    "2.2.2.2",
+				ElasticIp:   "2.2.2.2",
+				SecondaryIp: "10.0.1.1",
+				Lifecycle:   "on-demand",
+			},
+		},
+		{
+			desc: "ipv6 addresses",
+			instance: &ec2.Instance{
+				InstanceId:       aws.String("i-1"),
+				PrivateIpAddress: aws.String("10.0.0.1"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.0.1"),
+						Ipv6Addresses: []*ec2.InstanceIpv6Address{
+							{Ipv6Address: aws.String("2600:1f18::1")},
+							{Ipv6Address: aws.String("2600:1f18::2")},
+						},
+					},
+					{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+						PrivateIpAddress: aws.String("10.0.1.1"),
+						Ipv6Addresses: []*ec2.InstanceIpv6Address{
+							{Ipv6Address: aws.String("2600:1f18::3")},
+						},
+					},
+				},
+			},
+			expected: &Instance{
+				Id:              "i-1",
+				PrivateIp:       "10.0.0.1",
+				SecondaryIp:     "10.0.1.1",
+				Ipv6Ip:          "2600:1f18::1",
+				SecondaryIpv6Ip: "2600:1f18::3",
+				Lifecycle:       "on-demand",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, newInstance(tc.instance, nil))
+		})
+	}
+}
+
+func TestListZoneRecordsPaginates(t *testing.T) {
+	route53Client := &fakeRoute53{
+		pageSize: 2,
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "NS", "ns1."),
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+			newTestRecordSet("_auto53-a.rec1.apex1.", "TXT", ownershipValue(DefaultOwner)),
+			newTestRecordSet("_auto53-a.rec3.apex1.", "TXT", ownershipValue("other")),
+			newTestRecordSet("rec1.apex1.", "A", "10.0.0.1"),
+			newTestRecordSet("rec2.apex1.", "A", "10.0.0.2", "10.0.0.3"),
+			newTestRecordSet("rec3.apex1.", "A", "10.0.0.4"),
+		},
+	}
+
+	a := newTestAuto(nil)
+	a.route53 = route53Client
+
+	records, err := a.ListZoneRecords("zone123")
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, route53Client.calls)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, "rec1", records[0].Name)
+	assert.Equal(t, Zone{ID: "zone123", Name: "apex1"}, records[0].Zone)
+	assert.True(t, records[0].Owned)
+
+	assert.Equal(t, "rec2", records[1].Name)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3"}, records[1].Values)
+	assert.False(t, records[1].Owned)
+
+	assert.Equal(t, "rec3", records[2].Name)
+	assert.False(t, records[2].Owned)
+}
+
+func TestListZoneRecordsTypes(t *testing.T) {
+	route53Client := &fakeRoute53{
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+			newTestRecordSet("_auto53-aaaa.rec1.apex1.", "TXT", ownershipValue(DefaultOwner)),
+			newTestRecordSet("rec1.apex1.", "A", "10.0.0.1"),
+			newTestRecordSet("rec1.apex1.", "AAAA", "2600:1f18::1"),
+			newTestRecordSet("rec1.apex1.", "MX", "10 mail.apex1."),
+			newTestRecordSet("rec2.apex1.", "CNAME", "ip-10-0-0-1.ec2.internal."),
+			{
+				Name: aws.String("rec3.apex1."),
+				Type: aws.String("A"),
+				AliasTarget: &route53.AliasTarget{
+					DNSName:              aws.String("My-ALB.elb.amazonaws.com"),
+					HostedZoneId:         aws.String("Z35SXDOTRQ7X7K"),
+					EvaluateTargetHealth: aws.Bool(false),
+				},
+			},
+		},
+	}
+
+	a := newTestAuto(nil)
+	a.route53 = route53Client
+
+	records, err := a.ListZoneRecords("zone123")
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	assert.Equal(t, "CNAME", records[2].Type)
+	assert.Equal(t, []string{"ip-10-0-0-1.ec2.internal."}, records[2].Values)
+
+	assert.Equal(t, &AliasTarget{
+		DNSName:      "my-alb.elb.amazonaws.com.",
+		HostedZoneId: "Z35SXDOTRQ7X7K",
+	}, records[3].Alias)
+	assert.Empty(t, records[3].Values)
+
+	assert.Equal(t, "A", records[0].Type)
+	assert.False(t, records[0].Owned)
+
+	assert.Equal(t, "AAAA", records[1].Type)
+	assert.Equal(t, []string{"2600:1f18::1"}, records[1].Values)
+	assert.True(t, records[1].Owned)
+}
+
+func TestReconcileHealthChecks(t *testing.T) {
+	var healthCheck = &HealthCheckConfig{
+		Type: route53.HealthCheckTypeHttp,
+		Port: 80,
+		Path: "/healthz",
+	}
+
+	stale := newTestRecordSet("web.apex1.", "A", "54.0.0.9")
+	stale.SetIdentifier = aws.String("i-9")
+	stale.MultiValueAnswer = aws.Bool(true)
+	stale.HealthCheckId = aws.String("hc-1")
+
+	staleOwnership := newTestRecordSet("_auto53-a.web.apex1.", "TXT", ownershipValue(DefaultOwner))
+	staleOwnership.SetIdentifier = aws.String("i-9")
+	staleOwnership.MultiValueAnswer = aws.Bool(true)
+
+	route53Client := &fakeRoute53{
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+			staleOwnership,
+			stale,
+		},
+		healthChecks: []*route53.HealthCheck{
+			{
+				Id:                aws.String("hc-1"),
+				CallerReference:   aws.String("auto53/default/54.0.0.9/1"),
+				HealthCheckConfig: healthCheckConfig("54.0.0.9", healthCheck),
+			},
+			{
+				Id:                aws.String("hc-2"),
+				CallerReference:   aws.String("auto53/default/54.0.0.1/1"),
+				HealthCheckConfig: healthCheckConfig("54.0.0.1", healthCheck),
+			},
+			{
+				Id:                aws.String("hc-3"),
+				CallerReference:   aws.String("auto53/other/54.0.0.5/1"),
+				HealthCheckConfig: healthCheckConfig("54.0.0.5", healthCheck),
+			},
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{ID: "zone123", Name: "apex1"},
+			Record:           "web",
+			Address:          AddressPublic,
+			HealthCheck:      healthCheck,
+		},
+	})
+	a.route53 = route53Client
+	a.ec2 = &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{
+				newTestInstance("i-1", "asg1", "54.0.0.1"),
+				newTestInstance("i-2", "asg1", "54.0.0.2"),
+			},
+		},
+	}
+
+	res, err := a.Reconcile(false)
+	require.NoError(t, err)
+
+	require.Len(t, res.Evaluations, 3)
+
+	assert.Equal(t, EvaluationRemoveRecord, res.Evaluations[0].Type)
+	assert.Equal(t, "i-9", res.Evaluations[0].Record.SetIdentifier)
+
+	assert.Equal(t, EvaluationAddRecord, res.Evaluations[1].Type)
+	assert.Equal(t, "i-1", res.Evaluations[1].Record.SetIdentifier)
+	assert.Equal(t, "hc-2", res.Evaluations[1].Record.HealthCheckId)
+
+	assert.Equal(t, EvaluationAddRecord, res.Evaluations[2].Type)
+	assert.Equal(t, "i-2", res.Evaluations[2].Record.SetIdentifier)
+	assert.Equal(t, "hc-4", res.Evaluations[2].Record.HealthCheckId)
+
+	require.Len(t, route53Client.healthChecks, 4)
+	assert.Equal(t, "54.0.0.2",
+		*route53Client.healthChecks[3].HealthCheckConfig.IPAddress)
+
+	// only the unused check of this owner is deleted.
+	assert.Equal(t, []string{"hc-1"}, route53Client.deletedHealthChecks)
+
+	require.Len(t, route53Client.changes, 1)
+	for _, change := range route53Client.changes[0].ChangeBatch.Changes {
+		assert.True(t, *change.ResourceRecordSet.MultiValueAnswer)
+		assert.NotEmpty(t, *change.ResourceRecordSet.SetIdentifier)
+	}
+}
+
+func TestReconcileAutoScalingGroupsCoversZones(t *testing.T) {
+	var reverse = &ReverseConfig{IPv4: &Zone{ID: "reverse", Name: "10.in-addr.arpa"}}
+
+	route53Client := &fakeRoute53{
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "a", Reverse: reverse},
+		{AutoScalingGroup: "asg2", Zone: Zone{ID: "zone2", Name: "apex1"}, Record: "b", Reverse: reverse},
+		{AutoScalingGroup: "asg3", Zone: Zone{ID: "zone2", Name: "apex1"}, Record: "c"},
+		{AutoScalingGroup: "asg4", Zone: Zone{ID: "zone3", Name: "apex1"}, Record: "d"},
+	})
+	a.route53 = route53Client
+	a.ec2 = &fakeEC2{pages: [][]*ec2.Instance{{}}}
+
+	res, err := a.ReconcileAutoScalingGroups(true, []string{"asg1"})
+	require.NoError(t, err)
+
+	// asg2 shares the reverse zone with asg1 and asg3
+	// shares zone2 with asg2.
+	assert.Len(t, res.AutoScalingGroups, 3)
+	assert.Contains(t, res.AutoScalingGroups, "asg3")
+	assert.NotContains(t, res.AutoScalingGroups, "asg4")
+}
+
+func TestReconcileOverwritesOrphanedOwnership(t *testing.T) {
+	var testCases = []struct {
+		desc  string
+		owner string
+	}{
+		{desc: "left by this owner", owner: DefaultOwner},
+		{desc: "left by another owner", owner: "other"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			route53Client := &fakeRoute53{
+				recordSets: []*route53.ResourceRecordSet{
+					newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+					newTestRecordSet("_auto53-a.web.apex1.", "TXT", ownershipValue(tc.owner)),
+				},
+			}
+
+			a := newTestAuto([]*FormattingRule{
+				{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "web"},
+			})
+			a.route53 = route53Client
+			a.ec2 = &fakeEC2{
+				pages: [][]*ec2.Instance{
+					{newTestInstance("i-1", "asg1", "10.0.0.1")},
+				},
+			}
+
+			res, err := a.Reconcile(false)
+			require.NoError(t, err)
+
+			assert.Empty(t, res.Conflicts)
+			require.Len(t, res.Evaluations, 1)
+			assert.Equal(t, EvaluationAddRecord, res.Evaluations[0].Type)
+
+			require.Len(t, route53Client.changes, 1)
+
+			changes := route53Client.changes[0].ChangeBatch.Changes
+			require.Len(t, changes, 2)
+
+			assert.Equal(t, route53.ChangeActionUpsert, *changes[0].Action)
+			assert.Equal(t, "_auto53-a.web.apex1.", *changes[0].ResourceRecordSet.Name)
+			assert.Equal(t, ownershipValue(DefaultOwner),
+				*changes[0].ResourceRecordSet.ResourceRecords[0].Value)
+
+			assert.Equal(t, route53.ChangeActionCreate, *changes[1].Action)
+			assert.Equal(t, "web.apex1.", *changes[1].ResourceRecordSet.Name)
+		})
+	}
+}
+
+func TestReconcileMatchesListedNames(t *testing.T) {
+	route53Client := &fakeRoute53{
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+			newTestRecordSet("web.apex1.", "A", "10.0.0.1"),
+			newTestRecordSet("_auto53-a.web.apex1.", "TXT", ownershipValue(DefaultOwner)),
+			newTestRecordSet(`\052.api.apex1.`, "A", "10.0.0.1"),
+			newTestRecordSet(`_auto53-a.\052.api.apex1.`, "TXT", ownershipValue(DefaultOwner)),
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "Web"},
+		{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "*.api"},
+	})
+	a.route53 = route53Client
+	a.ec2 = &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{newTestInstance("i-1", "asg1", "10.0.0.1")},
+		},
+	}
+
+	// Route53 lists names lowercased and with
+	// wildcards escaped.
+	res, err := a.Reconcile(true)
+	require.NoError(t, err)
+
+	assert.Empty(t, res.Conflicts)
+	assert.Empty(t, res.Evaluations)
+}
+
+func TestEvaluationChangesAlias(t *testing.T) {
+	a := newTestAuto(nil)
+
+	changes, err := a.evaluationChanges(&Evaluation{
+		Type: EvaluationAddRecord,
+		Record: &Record{
+			Zone:   Zone{ID: "zone123", Name: "apex1"},
+			Name:   "rec1",
+			Type:   "A",
+			Values: []string{},
+			Alias: &AliasTarget{
+				DNSName:      "my-alb.elb.amazonaws.com.",
+				HostedZoneId: "Z35SXDOTRQ7X7K",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+
+	recordSet := changes[1].ResourceRecordSet
+	assert.Nil(t, recordSet.TTL)
+	assert.Empty(t, recordSet.ResourceRecords)
+	require.NotNil(t, recordSet.AliasTarget)
+	assert.Equal(t, "my-alb.elb.amazonaws.com.", *recordSet.AliasTarget.DNSName)
+	assert.Equal(t, "Z35SXDOTRQ7X7K", *recordSet.AliasTarget.HostedZoneId)
+}
+
+func TestExecuteEvaluationsIsolatesZones(t *testing.T) {
+	route53Client := &fakeRoute53{
+		changeErrors: map[string][]error{
+			"zone1": {
+				awserr.New("Throttling", "Rate exceeded", nil),
+				awserr.New("PriorRequestNotComplete", "busy", nil),
+			},
+			"zone2": {
+				awserr.New("InvalidChangeBatch", "invalid", nil),
+			},
+		},
+	}
+
+	a := newTestAuto(nil)
+	a.route53 = route53Client
+	a.route53MaxRetries = 3
+
+	results, err := a.ExecuteEvaluations([]*Evaluation{
+		{
+			Type: EvaluationAddRecord,
+			Record: &Record{
+				Zone:   Zone{ID: "zone1", Name: "apex1"},
+				Name:   "rec1",
+				Values: []string{"10.0.0.1"},
+			},
+		},
+		{
+			Type: EvaluationAddRecord,
+			Record: &Record{
+				Zone:   Zone{ID: "zone2", Name: "apex2"},
+				Name:   "rec1",
+				Values: []string{"10.0.0.1"},
+			},
+		},
+		{
+			Type: EvaluationAddRecord,
+			Record: &Record{
+				Zone:   Zone{ID: "zone3", Name: "apex3"},
+				Name:   "rec1",
+				Values: []string{"10.0.0.1"},
+			},
+		},
+	})
+	require.Error(t, err)
+	require.Len(t, results, 3)
+
+	assert.Equal(t, "zone1", results[0].Zone)
+	assert.Len(t, results[0].Changes, 1)
+	assert.Empty(t, results[0].Error)
+
+	assert.Equal(t, "zone2", results[1].Zone)
+	assert.Len(t, results[1].Changes, 0)
+	assert.NotEmpty(t, results[1].Error)
+
+	assert.Equal(t, "zone3", results[2].Zone)
+	assert.Len(t, results[2].Changes, 1)
+	assert.Empty(t, results[2].Error)
+
+	require.Len(t, route53Client.changes, 2)
+	assert.Len(t, route53Client.changes[0].ChangeBatch.Changes, 2)
+}
+
+func TestExecuteEvaluationsWaitsInSync(t *testing.T) {
+	var evals = []*Evaluation{
+		{
+			Type: EvaluationAddRecord,
+			Record: &Record{
+				Zone:   Zone{ID: "zone1", Name: "apex1"},
+				Name:   "rec1",
+				Values: []string{"10.0.0.1"},
+			},
+		},
+	}
+
+	route53Client := &fakeRoute53{pendingPolls: 2}
+
+	a := newTestAuto(nil)
+	a.route53 = route53Client
+	a.waitInSync = true
+	a.waitTimeout = time.Minute
+
+	results, err := a.ExecuteEvaluations(evals)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Changes, 1)
+
+	assert.Equal(t, route53.ChangeStatusInsync, results[0].Changes[0].Status)
+	assert.Equal(t, 3, route53Client.polls[results[0].Changes[0].ID])
+
+	route53Client = &fakeRoute53{pendingPolls: 1000}
+	a.route53 = route53Client
+	a.waitTimeout = 10 * time.Millisecond
+	a.waitPollInterval = time.Millisecond
+
+	results, err = a.ExecuteEvaluations(evals)
+	require.Error(t, err)
+	require.Len(t, results, 1)
+
+	assert.Equal(t, route53.ChangeStatusPending, results[0].Changes[0].Status)
+	assert.Contains(t, results[0].Error, "not INSYNC")
+}
+
+func TestGetZonesRecordsAggregatesErrors(t *testing.T) {
+	route53Client := &fakeRoute53{
+		recordSets: []*route53.ResourceRecordSet{
+			newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+			newTestRecordSet("rec1.apex1.", "A", "10.0.0.1"),
+		},
+		listErrors: map[string]error{
+			"zone2": awserr.New("NoSuchHostedZone", "not found", nil),
+			"zone4": awserr.New("AccessDenied", "denied", nil),
+		},
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{Zone: Zone{ID: "zone1", Name: "apex1"}},
+		{Zone: Zone{ID: "zone2", Name: "apex2"}},
+		{Zone: Zone{ID: "zone3", Name: "apex3"}},
+		{Zone: Zone{ID: "zone4", Name: "apex4"}},
+		{Zone: Zone{ID: "zone1", Name: "apex1"}},
+	})
+	a.route53 = route53Client
+	a.concurrency = 2
+
+	_, err := a.GetZonesRecords()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 out of 4 zones")
+	assert.Contains(t, err.Error(), "zone2: NoSuchHostedZone")
+	assert.Contains(t, err.Error(), "zone4: AccessDenied")
+
+	delete(route53Client.listErrors, "zone2")
+	delete(route53Client.listErrors, "zone4")
+
+	recordsMap, err := a.GetZonesRecords()
+	require.NoError(t, err)
+	assert.Len(t, recordsMap, 4)
+	assert.Len(t, recordsMap["zone3"], 1)
+}
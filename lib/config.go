@@ -0,0 +1,420 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigVersion is the version of the configuration
+// schema that auto53 understands.
+const ConfigVersion = 1
+
+// Config is the versioned configuration of auto53:
+//
+//	Version: 1
+//	Rules:
+//	  - AutoScalingGroup: 'asg1'
+//	    Zone: {ID: 'zone123', Name: 'ciro-test'}
+//	    Record: '{{ .Id }}'
+//
+// A bare list of formatting rules is still accepted as
+// an unversioned configuration.
+type Config struct {
+	Version int               `yaml:"Version"`
+	Rules   []*FormattingRule `yaml:"Rules"`
+}
+
+// ConfigError is a problem found in a configuration.
+type ConfigError struct {
+
+	// Line is the line of the configuration that the
+	// problem refers to (starting at 1), if known.
+	Line int
+
+	// Rule is the index of the rule that the problem
+	// refers to (starting at 1), if any.
+	Rule int
+
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	var prefix string
+
+	if e.Line != 0 {
+		prefix += "line " + strconv.Itoa(e.Line) + ": "
+	}
+
+	if e.Rule != 0 {
+		prefix += "rule " + strconv.Itoa(e.Rule) + ": "
+	}
+
+	return prefix + e.Err.Error()
+}
+
+// ConfigErrors aggregates all the problems found in a
+// configuration, in order of line.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	var msgs = make([]string, 0, len(e))
+
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+var yamlErrorRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// ParseConfig strictly decodes a configuration,
+// rejecting unknown keys and unsupported versions, and
+// validates its rules (see ValidateFormattingRules).
+//
+// Every problem found is reported in ConfigErrors.
+func ParseConfig(content []byte) (rules []*FormattingRule, err error) {
+	var (
+		document  interface{}
+		versioned bool
+		config    Config
+	)
+
+	err = yaml.Unmarshal(content, &document)
+	if err != nil {
+		err = yamlConfigErrors(err)
+		return
+	}
+
+	switch document.(type) {
+	case nil, []interface{}:
+		err = yaml.UnmarshalStrict(content, &rules)
+	default:
+		versioned = true
+		err = yaml.UnmarshalStrict(content, &config)
+		rules = config.Rules
+	}
+
+	if err != nil {
+		err = yamlConfigErrors(err)
+		return
+	}
+
+	if versioned && config.Version != ConfigVersion {
+		err = ConfigErrors{{
+			Line: keyLine(content, "Version"),
+			Err: errors.Errorf(
+				"unsupported Version %d - expected %d",
+				config.Version, ConfigVersion),
+		}}
+		return
+	}
+
+	for _, rule := range rules {
+		if rule != nil {
+			rule.normalizeZones()
+		}
+	}
+
+	var configErrs = ValidateFormattingRules(rules)
+	if len(configErrs) == 0 {
+		return
+	}
+
+	var lines = ruleLines(content, versioned)
+	for _, configErr := range configErrs {
+		if configErr.Rule > 0 && configErr.Rule <= len(lines) {
+			configErr.Line = lines[configErr.Rule-1]
+		}
+	}
+
+	sort.SliceStable(configErrs, func(i, j int) bool {
+		return configErrs[i].Line < configErrs[j].Line
+	})
+
+	err = configErrs
+	return
+}
+
+// yamlConfigErrors converts the errors reported by the
+// yaml decoder into ConfigErrors.
+func yamlConfigErrors(err error) (configErrs ConfigErrors) {
+	var msgs = []string{err.Error()}
+
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+
+	for _, msg := range msgs {
+		var configErr = &ConfigError{Err: errors.New(msg)}
+
+		match := yamlErrorRegexp.FindStringSubmatch(msg)
+		if match != nil {
+			configErr.Line, _ = strconv.Atoi(match[1])
+			configErr.Err = errors.New(match[2])
+		}
+
+		configErrs = append(configErrs, configErr)
+	}
+
+	return
+}
+
+// ValidateFormattingRules verifies every rule (parsing
+// its templates) and looks for rules that produce the
+// same records differently.
+func ValidateFormattingRules(rules []*FormattingRule) (configErrs ConfigErrors) {
+	var valid []int
+
+	if len(rules) == 0 {
+		configErrs = append(configErrs, &ConfigError{
+			Err: errors.Errorf("at least one rule must be specified"),
+		})
+		return
+	}
+
+	for i, rule := range rules {
+		var errs = validateRule(rule)
+
+		for _, err := range errs {
+			configErrs = append(configErrs, &ConfigError{Rule: i + 1, Err: err})
+		}
+
+		if len(errs) == 0 {
+			valid = append(valid, i)
+		}
+	}
+
+	for x, i := range valid {
+		for _, j := range valid[:x] {
+			err := compareRules(rules[j], rules[i], j+1)
+			if err != nil {
+				configErrs = append(configErrs, &ConfigError{Rule: i + 1, Err: err})
+			}
+		}
+	}
+
+	return
+}
+
+// validateRule retrieves all the problems of a rule.
+func validateRule(rule *FormattingRule) (errs []error) {
+	if rule == nil {
+		errs = append(errs, errors.Errorf("rule is empty"))
+		return
+	}
+
+	if rule.AutoScalingGroup == "" && rule.Selector == nil {
+		errs = append(errs, errors.Errorf(
+			"rule for record '%s' must have an AutoScalingGroup or a Selector",
+			rule.Record))
+	}
+
+	if rule.Ordinals && rule.AutoScalingGroup == "" {
+		errs = append(errs, errors.Errorf(
+			"rule for record '%s' requires an AutoScalingGroup for Ordinals",
+			rule.Record))
+	}
+
+	if rule.Record == "" {
+		errs = append(errs, errors.Errorf("rule must have a Record"))
+	}
+
+	// records are named after their zones, so zones
+	// can't be given by ID alone.
+	for _, zone := range rule.Zones() {
+		if zone.Name == "" {
+			errs = append(errs, errors.Errorf(
+				"rule for record '%s' has a zone without Name",
+				rule.Record))
+		}
+	}
+
+	errs = appendError(errs, rule.ParseRecordTemplate())
+
+	_, err := rule.AddressType()
+	errs = appendError(errs, err)
+
+	_, err = rule.RecordTypes()
+	errs = appendError(errs, err)
+
+	_, err = rule.MembershipPolicy()
+	errs = appendError(errs, err)
+
+	_, err = rule.RoutingPolicy()
+	errs = appendError(errs, err)
+
+	if rule.TTL < 0 {
+		errs = append(errs, errors.Errorf(
+			"rule for record '%s' must have a positive TTL - %d provided",
+			rule.Record, rule.TTL))
+	}
+
+	if rule.HealthCheck != nil {
+		errs = appendError(errs, rule.HealthCheck.Validate())
+	}
+
+	if rule.Selector != nil {
+		errs = appendError(errs, rule.Selector.Validate())
+	}
+
+	if rule.Reverse != nil {
+		errs = appendError(errs, rule.Reverse.Validate())
+	}
+
+	return
+}
+
+func appendError(errs []error, err error) []error {
+	if err == nil {
+		return errs
+	}
+
+	return append(errs, err)
+}
+
+// compareRules looks for a rule that duplicates
+// `previous` (the rule number `number`) or that
+// produces the same records out of a different source
+// (e.g., another address of the instances) or with
+// different attributes.
+//
+// Only rules whose Record templates and zones are the
+// same are compared.
+func compareRules(previous, rule *FormattingRule, number int) (err error) {
+	if previous.Record != rule.Record ||
+		zoneReference(previous.Zone) != zoneReference(rule.Zone) ||
+		!overlappingTypes(previous, rule) {
+		return
+	}
+
+	previousContent, _ := yaml.Marshal(previous)
+	content, _ := yaml.Marshal(rule)
+
+	if bytes.Equal(previousContent, content) {
+		err = errors.Errorf(
+			"rule for record '%s' duplicates rule %d",
+			rule.Record, number)
+		return
+	}
+
+	// templated names of different groups don't
+	// necessarily render the same.
+	if strings.Contains(rule.Record, "{{") &&
+		previous.GroupName() != rule.GroupName() {
+		return
+	}
+
+	if ruleSource(previous) != ruleSource(rule) {
+		err = errors.Errorf(
+			"rule for record '%s' points to %s while rule %d points to %s",
+			rule.Record, ruleSource(rule), number, ruleSource(previous))
+		return
+	}
+
+	previousRouting, _ := previous.RoutingPolicy()
+	routing, _ := rule.RoutingPolicy()
+
+	if previous.TTL != rule.TTL ||
+		!reflect.DeepEqual(previousRouting, routing) ||
+		!reflect.DeepEqual(previous.HealthCheck, rule.HealthCheck) {
+		err = errors.Errorf(
+			"rule for record '%s' disagrees with rule %d on its TTL, routing policy or health check",
+			rule.Record, number)
+		return
+	}
+
+	return
+}
+
+// zoneReference identifies the zone as specified in a
+// rule.
+func zoneReference(zone Zone) string {
+	if zone.ID != "" {
+		return zone.ID
+	}
+
+	return zoneCacheKey(&zone)
+}
+
+func overlappingTypes(a, b *FormattingRule) bool {
+	aTypes, _ := a.RecordTypes()
+	bTypes, _ := b.RecordTypes()
+
+	for _, recordType := range aTypes {
+		if containsString(bTypes, recordType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleSource describes where the values of the records
+// of a rule come from.
+func ruleSource(rule *FormattingRule) string {
+	if rule.Target != "" {
+		return fmt.Sprintf("target '%s'", rule.Target)
+	}
+
+	addressType, _ := rule.AddressType()
+	return fmt.Sprintf("%s addresses", addressType)
+}
+
+// ruleLines retrieves the line (starting at 1) where
+// each of the rules of a configuration starts, as long
+// as the rules are written as a block sequence.
+func ruleLines(content []byte, versioned bool) (lines []int) {
+	var (
+		inRules = !versioned
+		indent  = -1
+	)
+
+	for i, line := range strings.Split(string(content), "\n") {
+		var (
+			trimmed = strings.TrimLeft(line, " ")
+			depth   = len(line) - len(trimmed)
+			item    = trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") ||
+			trimmed == "---" || trimmed == "..." {
+			continue
+		}
+
+		if !inRules {
+			inRules = depth == 0 && strings.HasPrefix(trimmed, "Rules:")
+			continue
+		}
+
+		switch {
+		case item && (indent == -1 || depth == indent):
+			indent = depth
+			lines = append(lines, i+1)
+		case versioned && depth == 0 && !item:
+			return
+		}
+	}
+
+	return
+}
+
+// keyLine retrieves the line (starting at 1) of a top
+// level key of a configuration, if present.
+func keyLine(content []byte, key string) int {
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, key+":") {
+			return i + 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	var testCases = []struct {
+		desc     string
+		content  string
+		rules    int
+		expected []string
+	}{
+		{
+			desc: "versioned",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: '{{ .Id }}'
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'asg1'
+`,
+			rules: 2,
+		},
+		{
+			desc: "unversioned list",
+			content: `
+- AutoScalingGroup: 'asg1'
+  Zone: {ID: 'zone123', Name: 'ciro-test'}
+  Record: '{{ .Id }}'
+`,
+			rules: 1,
+		},
+		{
+			desc: "unsupported version",
+			content: `
+Version: 2
+Rules: []
+`,
+			expected: []string{"line 2: unsupported Version 2 - expected 1"},
+		},
+		{
+			desc: "unknown keys",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Recrod: '{{ .Id }}'
+`,
+			expected: []string{"line 4: field Recrod not found in struct lib.FormattingRule"},
+		},
+		{
+			desc: "every problem of every rule",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Record: '{{ .Id }'
+
+  - Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Membership: 'sometimes'
+`,
+			expected: []string{
+				"line 4: rule 1: rule for record '{{ .Id }' has a zone without Name",
+				"line 4: rule 1: failed to instantiate template for record '{{ .Id }': template: tmpl:1: unexpected \"}\" in operand",
+				"line 7: rule 2: rule for record 'api' must have an AutoScalingGroup or a Selector",
+				"line 7: rule 2: rule for record 'api' has unknown Membership sometimes",
+			},
+		},
+		{
+			desc: "zone without name",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123'}
+    Record: 'api'
+`,
+			expected: []string{"line 4: rule 1: rule for record 'api' has a zone without Name"},
+		},
+		{
+			desc: "reverse zones",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Reverse:
+      IPv4: {ID: 'zone456', Name: '10.in-addr.arpa.'}
+      IPv6: {ID: 'zone789', Name: '8.b.d.0.1.0.0.2.ip6.arpa'}
+`,
+			rules: 1,
+		},
+		{
+			desc: "reverse zone without name",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Reverse:
+      IPv4: {ID: 'zone456'}
+`,
+			expected: []string{
+				"line 4: rule 1: rule for record 'api' has a zone without Name",
+				"line 4: rule 1: reverse zone '' must be an in-addr.arpa zone",
+			},
+		},
+		{
+			desc: "reverse zone of another family",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Reverse:
+      IPv4: {ID: 'zone456', Name: 'ciro-test'}
+      IPv6: {ID: 'zone789', Name: '10.in-addr.arpa'}
+`,
+			expected: []string{
+				"line 4: rule 1: reverse zone 'ciro-test' must be an in-addr.arpa zone",
+			},
+		},
+		{
+			desc: "duplicate rules",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+`,
+			expected: []string{"line 7: rule 2: rule for record 'api' duplicates rule 1"},
+		},
+		{
+			desc: "same name from different addresses",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+  - AutoScalingGroup: 'asg2'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Address: 'public'
+`,
+			expected: []string{
+				"line 7: rule 2: rule for record 'api' points to public addresses while rule 1 points to private addresses",
+			},
+		},
+		{
+			desc: "health check of private addresses",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    HealthCheck: {Type: 'HTTP', Port: 80}
+`,
+			expected: []string{
+				"line 4: rule 1: rule for record 'api' can only have a HealthCheck with public or elastic addresses - private provided",
+			},
+		},
+		{
+			desc: "health check of public addresses",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: 'api'
+    Address: 'public'
+    HealthCheck: {Type: 'HTTP', Port: 80}
+`,
+			rules: 1,
+		},
+		{
+			desc: "templated names of different groups",
+			content: `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: '{{ .Id }}'
+  - AutoScalingGroup: 'asg2'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: '{{ .Id }}'
+    Address: 'public'
+`,
+			rules: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rules, err := ParseConfig([]byte(tc.content))
+			if len(tc.expected) == 0 {
+				require.NoError(t, err)
+				assert.Len(t, rules, tc.rules)
+				return
+			}
+
+			require.Error(t, err)
+
+			configErrs, ok := errors.Cause(err).(ConfigErrors)
+			require.True(t, ok)
+
+			var msgs []string
+			for _, configErr := range configErrs {
+				msgs = append(msgs, configErr.Error())
+			}
+
+			assert.Equal(t, tc.expected, msgs)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultPollInterval is the default interval
+	// between checks of the configuration file when
+	// file notifications are not available.
+	DefaultPollInterval = 10 * time.Second
+
+	// watchDebounce is how long the watcher waits for
+	// notifications to settle before checking the file,
+	// given that editors write files in several steps.
+	watchDebounce = 200 * time.Millisecond
+)
+
+// ConfigWatcher reloads the formatting rules of a
+// Reconciler whenever the configuration file changes.
+//
+// Changes are noticed through inotify on Linux and by
+// polling the file otherwise. The file is reloaded only
+// if its content changed.
+type ConfigWatcher struct {
+	logger       zerolog.Logger
+	reconciler   *Reconciler
+	file         string
+	load         func() ([]*FormattingRule, error)
+	pollInterval time.Duration
+
+	digestMtx sync.Mutex
+	digest    []byte
+}
+
+type ConfigWatcherConfig struct {
+	Reconciler *Reconciler
+
+	// File is the configuration file to watch. If not
+	// set, rules are only reloaded via Reload.
+	File string
+
+	// Load retrieves the formatting rules. Defaults to
+	// reading them from File.
+	Load func() ([]*FormattingRule, error)
+
+	// PollInterval is the interval between checks of
+	// File when file notifications are not available.
+	// Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+func NewConfigWatcher(cfg ConfigWatcherConfig) (w *ConfigWatcher, err error) {
+	if cfg.Reconciler == nil {
+		err = errors.Errorf("Reconciler must be specified")
+		return
+	}
+
+	if cfg.File == "" && cfg.Load == nil {
+		err = errors.Errorf("File or Load must be specified")
+		return
+	}
+
+	if cfg.PollInterval < 0 {
+		err = errors.Errorf(
+			"PollInterval must be positive - %s provided",
+			cfg.PollInterval)
+		return
+	}
+
+	w = &ConfigWatcher{}
+	w.reconciler = cfg.Reconciler
+	w.file = cfg.File
+	w.load = cfg.Load
+	if w.load == nil {
+		w.load = func() ([]*FormattingRule, error) {
+			return FormattingRulesFromYamlFile(cfg.File)
+		}
+	}
+
+	w.pollInterval = cfg.PollInterval
+	if w.pollInterval == 0 {
+		w.pollInterval = DefaultPollInterval
+	}
+
+	w.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "config-watcher").
+		Logger()
+
+	if w.file != "" {
+		w.digest, _ = fileDigest(w.file)
+	}
+
+	return
+}
+
+// Reload reloads the formatting rules right away (e.g.,
+// on SIGHUP), regardless of whether the file changed.
+func (w *ConfigWatcher) Reload() (err error) {
+	if w.file != "" {
+		digest, _ := fileDigest(w.file)
+
+		w.digestMtx.Lock()
+		w.digest = digest
+		w.digestMtx.Unlock()
+	}
+
+	err = w.reconciler.ReloadFormattingRules(w.load)
+	return
+}
+
+// Run watches the configuration file until the context
+// gets cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) {
+	if w.file == "" {
+		return
+	}
+
+	var (
+		notifications <-chan struct{}
+		ticker        *time.Ticker
+		ticks         <-chan time.Time
+		debounce      <-chan time.Time
+	)
+
+	notifications, stop, err := watchFile(w.file)
+	if err != nil {
+		w.logger.Warn().
+			Err(err).
+			Dur("interval", w.pollInterval).
+			Msg("file notifications unavailable - polling the configuration file")
+
+		ticker = time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	} else {
+		defer stop()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notifications:
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			w.reloadIfChanged()
+		case <-ticks:
+			w.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged reloads the formatting rules if the
+// content of the file changed since the last reload.
+func (w *ConfigWatcher) reloadIfChanged() {
+	digest, err := fileDigest(w.file)
+	if err != nil {
+		w.logger.Error().
+			Err(err).
+			Msg("failed to read configuration file")
+		return
+	}
+
+	w.digestMtx.Lock()
+	changed := !bytes.Equal(digest, w.digest)
+	w.digest = digest
+	w.digestMtx.Unlock()
+
+	if !changed {
+		return
+	}
+
+	w.logger.Info().
+		Str("file", w.file).
+		Msg("configuration file changed")
+
+	w.reconciler.ReloadFormattingRules(w.load)
+}
+
+func fileDigest(file string) (digest []byte, err error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	digest = sum[:]
+	return
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {ID: 'zone123', Name: 'ciro-test'}
+    Record: '%s'
+`
+
+func writeTestConfig(t *testing.T, file, record string) {
+	content := []byte(fmt.Sprintf(testConfig, record))
+	require.NoError(t, ioutil.WriteFile(file+".tmp", content, 0644))
+	require.NoError(t, os.Rename(file+".tmp", file))
+}
+
+func newTestReconciler(t *testing.T, rules []*FormattingRule) *Reconciler {
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestAuto(rules),
+		Interval: time.Minute,
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestReconcilerReloadFormattingRules(t *testing.T) {
+	var (
+		previous = []*FormattingRule{{AutoScalingGroup: "asg1", Record: "previous"}}
+		r        = newTestReconciler(t, previous)
+	)
+
+	err := r.ReloadFormattingRules(func() ([]*FormattingRule, error) {
+		return ParseConfig([]byte("Version: 1\nRules:\n  - Recrod: 'api'\n"))
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, previous, r.auto.FormattingRules())
+	assert.Contains(t, r.Status().ConfigError, "field Recrod not found")
+	assert.True(t, r.Status().LastReload.IsZero())
+
+	err = r.ReloadFormattingRules(func() ([]*FormattingRule, error) {
+		return []*FormattingRule{{AutoScalingGroup: "asg1", Record: "next"}}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "next", r.auto.FormattingRules()[0].Record)
+	assert.Empty(t, r.Status().ConfigError)
+	assert.False(t, r.Status().LastReload.IsZero())
+}
+
+func TestConfigWatcherReloadsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auto53")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "auto53.yaml")
+	writeTestConfig(t, file, "first")
+
+	rules, err := FormattingRulesFromYamlFile(file)
+	require.NoError(t, err)
+
+	r := newTestReconciler(t, rules)
+
+	w, err := NewConfigWatcher(ConfigWatcherConfig{
+		Reconciler:   r,
+		File:         file,
+		PollInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go w.Run(ctx)
+
+	// gives the watcher time to start watching.
+	time.Sleep(50 * time.Millisecond)
+	writeTestConfig(t, file, "second")
+
+	var deadline = time.Now().Add(5 * time.Second)
+	for r.auto.FormattingRules()[0].Record != "second" {
+		require.True(t, time.Now().Before(deadline), "rules not reloaded")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
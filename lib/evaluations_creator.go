@@ -8,14 +8,19 @@ import (
 // evaluations to be performed on the current
 // state to reach the desired state.
 //
-// It discovers which elements in a given array
-// are missing and which ones are meant to be
-// deleted.
+// Records are matched by their keys (zone and name):
+//   - a desired record without a current counterpart
+//     is added;
+//   - a desired record whose current counterpart has
+//     a different hash is updated in place (so that the
+//     name never stops resolving);
+//   - a current record without a desired counterpart
+//     is removed.
 //
-// For each record, take the hash of it.
-// Given the two arrays of hashed objects, calculate
-// the additions and removals by looking at a map of current
-// records and a map of desired records.
+// Current records that are not owned are never
+// updated nor removed, and desired records whose
+// names are taken by them are not added (see
+// FindConflicts).
 func GetEvaluations(current, desired []*Record) (evals []*Evaluation, err error) {
 	if current == nil || desired == nil {
 		err = errors.Errorf("current and desired must be non-nil")
@@ -23,37 +28,42 @@ func GetEvaluations(current, desired []*Record) (evals []*Evaluation, err error)
 	}
 
 	var (
-		currentMap = map[uint64]*Record{}
-		desiredMap = map[uint64]*Record{}
+		currentMap = map[string]*Record{}
+		desiredMap = map[string]*Record{}
+		c, d       *Record
 		present    bool
 	)
 
-	for _, c := range current {
+	for _, c = range current {
 		err = c.ComputeHash()
 		if err != nil {
 			err = errors.Wrapf(err, "failed to compute hash of record")
 			return
 		}
 
-		currentMap[c.hash] = c
+		currentMap[c.Key()] = c
 	}
 
-	for _, d := range desired {
+	for _, d = range desired {
 		err = d.ComputeHash()
 		if err != nil {
 			err = errors.Wrapf(err, "failed to compute hash of record")
 			return
 		}
 
-		desiredMap[d.hash] = d
+		desiredMap[d.Key()] = d
 	}
 
 	evals = make([]*Evaluation, 0)
 
 	// if currentState has something that is
 	// not in the desiredState: delete
-	for cHash, c := range currentMap {
-		_, present = desiredMap[cHash]
+	for _, c = range current {
+		if !c.Owned {
+			continue
+		}
+
+		_, present = desiredMap[c.Key()]
 		if present {
 			continue
 		}
@@ -64,18 +74,76 @@ func GetEvaluations(current, desired []*Record) (evals []*Evaluation, err error)
 		})
 	}
 
-	// if desiredState has something that is
-	// not in the currentState: add
-	for dHash, d := range desiredMap {
-		_, present = currentMap[dHash]
-		if present {
+	for _, d = range desired {
+		c, present = currentMap[d.Key()]
+
+		// if desiredState has something that is
+		// not in the currentState: add
+		if !present {
+			evals = append(evals, &Evaluation{
+				Type:   EvaluationAddRecord,
+				Record: d,
+			})
 			continue
 		}
 
-		evals = append(evals, &Evaluation{
-			Type:   EvaluationAddRecord,
-			Record: d,
-		})
+		// if both have it but differently: update
+		if c.Owned && c.hash != d.hash {
+			evals = append(evals, &Evaluation{
+				Type:     EvaluationUpdateRecord,
+				Record:   d,
+				Previous: c,
+			})
+		}
+	}
+
+	return
+}
+
+// FindConflicts retrieves the desired records that
+// can't be applied because their names are taken
+// by records that are not owned by auto53.
+func FindConflicts(current, desired []*Record) (conflicts []*Record, err error) {
+	if current == nil || desired == nil {
+		err = errors.Errorf("current and desired must be non-nil")
+		return
+	}
+
+	var unowned = map[string]*Record{}
+
+	for _, c := range current {
+		if c.Owned {
+			continue
+		}
+
+		err = c.ComputeHash()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to compute hash of record")
+			return
+		}
+
+		unowned[c.Key()] = c
+	}
+
+	conflicts = make([]*Record, 0)
+
+	for _, d := range desired {
+		c, present := unowned[d.Key()]
+		if !present {
+			continue
+		}
+
+		err = d.ComputeHash()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to compute hash of record")
+			return
+		}
+
+		if c.hash == d.hash {
+			continue
+		}
+
+		conflicts = append(conflicts, d)
 	}
 
 	return
@@ -3,6 +3,7 @@ package lib
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -42,8 +43,8 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
 				},
 			},
 			expected: []*Evaluation{
@@ -54,8 +55,8 @@ func TestGetEvaluations(t *testing.T) {
 							Name: "apex1",
 							ID:   "zone123",
 						},
-						Name: "record1",
-						IPs:  []string{"1.1.1.1"},
+						Name:   "record1",
+						Values: []string{"1.1.1.1"},
 					},
 				},
 			},
@@ -69,8 +70,8 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
 				},
 			},
 			desired: []*Record{
@@ -79,8 +80,8 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
 				},
 			},
 			expected:    []*Evaluation{},
@@ -94,8 +95,9 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+					Owned:  true,
 				},
 			},
 			desired: []*Record{
@@ -104,46 +106,74 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"2.2.2.2"},
+					Name:   "record1",
+					Values: []string{"2.2.2.2"},
 				},
 			},
 			expected: []*Evaluation{
 				{
-					Type: EvaluationRemoveRecord,
+					Type: EvaluationUpdateRecord,
 					Record: &Record{
 						Zone: Zone{
 							Name: "apex1",
 							ID:   "zone123",
 						},
-						Name: "record1",
-						IPs:  []string{"1.1.1.1"},
+						Name:   "record1",
+						Values: []string{"2.2.2.2"},
 					},
 				},
+			},
+			shouldError: false,
+		},
+		{
+			desc: "update if ip-set changes with addition",
+			current: []*Record{
 				{
-					Type: EvaluationAddRecord,
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+					Owned:  true,
+				},
+			},
+			desired: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"1.1.1.1", "2.2.2.2"},
+				},
+			},
+			expected: []*Evaluation{
+				{
+					Type: EvaluationUpdateRecord,
 					Record: &Record{
 						Zone: Zone{
 							Name: "apex1",
 							ID:   "zone123",
 						},
-						Name: "record1",
-						IPs:  []string{"2.2.2.2"},
+						Name:   "record1",
+						Values: []string{"1.1.1.1", "2.2.2.2"},
 					},
 				},
 			},
 			shouldError: false,
 		},
 		{
-			desc: "update if ip-set changes with addition",
+			desc: "nothing if ip-set only changes order",
 			current: []*Record{
 				{
 					Zone: Zone{
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1"},
+					Name:   "record1",
+					Values: []string{"2.2.2.2", "1.1.1.1"},
+					Owned:  true,
 				},
 			},
 			desired: []*Record{
@@ -152,8 +182,34 @@ func TestGetEvaluations(t *testing.T) {
 						Name: "apex1",
 						ID:   "zone123",
 					},
-					Name: "record1",
-					IPs:  []string{"1.1.1.1", "2.2.2.2"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1", "2.2.2.2"},
+				},
+			},
+			expected:    []*Evaluation{},
+			shouldError: false,
+		},
+		{
+			desc: "removal and addition if names change",
+			current: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+					Owned:  true,
+				},
+			},
+			desired: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record2",
+					Values: []string{"1.1.1.1"},
 				},
 			},
 			expected: []*Evaluation{
@@ -164,8 +220,8 @@ func TestGetEvaluations(t *testing.T) {
 							Name: "apex1",
 							ID:   "zone123",
 						},
-						Name: "record1",
-						IPs:  []string{"1.1.1.1"},
+						Name:   "record1",
+						Values: []string{"1.1.1.1"},
 					},
 				},
 				{
@@ -175,8 +231,146 @@ func TestGetEvaluations(t *testing.T) {
 							Name: "apex1",
 							ID:   "zone123",
 						},
-						Name: "record1",
-						IPs:  []string{"1.1.1.1", "2.2.2.2"},
+						Name:   "record2",
+						Values: []string{"1.1.1.1"},
+					},
+				},
+			},
+			shouldError: false,
+		},
+		{
+			desc: "unowned records are never removed",
+			current: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+			desired:     []*Record{},
+			expected:    []*Evaluation{},
+			shouldError: false,
+		},
+		{
+			desc: "nothing if desired name is taken by unowned record",
+			current: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+			desired: []*Record{
+				{
+					Zone: Zone{
+						Name: "apex1",
+						ID:   "zone123",
+					},
+					Name:   "record1",
+					Values: []string{"2.2.2.2"},
+				},
+			},
+			expected:    []*Evaluation{},
+			shouldError: false,
+		},
+		{
+			desc: "update if ttl or weight change",
+			current: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Type:   "A",
+					TTL:    300,
+					Values: []string{"1.1.1.1"},
+					Owned:  true,
+				},
+				{
+					Zone:          Zone{Name: "apex1", ID: "zone123"},
+					Name:          "record2",
+					Type:          "A",
+					TTL:           300,
+					Values:        []string{"1.1.1.1"},
+					SetIdentifier: "blue",
+					Weight:        aws.Int64(10),
+					Owned:         true,
+				},
+			},
+			desired: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Type:   "A",
+					TTL:    60,
+					Values: []string{"1.1.1.1"},
+				},
+				{
+					Zone:          Zone{Name: "apex1", ID: "zone123"},
+					Name:          "record2",
+					Type:          "A",
+					TTL:           300,
+					Values:        []string{"1.1.1.1"},
+					SetIdentifier: "blue",
+					Weight:        aws.Int64(20),
+				},
+			},
+			expected: []*Evaluation{
+				{
+					Type: EvaluationUpdateRecord,
+					Record: &Record{
+						Name:   "record1",
+						Values: []string{"1.1.1.1"},
+					},
+				},
+				{
+					Type: EvaluationUpdateRecord,
+					Record: &Record{
+						Name:   "record2",
+						Values: []string{"1.1.1.1"},
+					},
+				},
+			},
+			shouldError: false,
+		},
+		{
+			desc: "record sets with different identifiers are independent",
+			current: []*Record{
+				{
+					Zone:          Zone{Name: "apex1", ID: "zone123"},
+					Name:          "record1",
+					Type:          "A",
+					Values:        []string{"1.1.1.1"},
+					SetIdentifier: "blue",
+					Owned:         true,
+				},
+			},
+			desired: []*Record{
+				{
+					Zone:          Zone{Name: "apex1", ID: "zone123"},
+					Name:          "record1",
+					Type:          "A",
+					Values:        []string{"1.1.1.1"},
+					SetIdentifier: "blue",
+				},
+				{
+					Zone:          Zone{Name: "apex1", ID: "zone123"},
+					Name:          "record1",
+					Type:          "A",
+					Values:        []string{"2.2.2.2"},
+					SetIdentifier: "green",
+				},
+			},
+			expected: []*Evaluation{
+				{
+					Type: EvaluationAddRecord,
+					Record: &Record{
+						Name:   "record1",
+						Values: []string{"2.2.2.2"},
 					},
 				},
 			},
@@ -199,6 +393,105 @@ func TestGetEvaluations(t *testing.T) {
 
 			require.NoError(t, err)
 			require.Equal(t, len(tc.expected), len(evals))
+
+			for i, eval := range evals {
+				assert.Equal(t, tc.expected[i].Type, eval.Type)
+				assert.Equal(t, tc.expected[i].Record.Name, eval.Record.Name)
+				assert.Equal(t, tc.expected[i].Record.Values, eval.Record.Values)
+			}
+		})
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	var testCases = []struct {
+		desc        string
+		current     []*Record
+		desired     []*Record
+		expected    []string
+		shouldError bool
+	}{
+		{
+			desc:        "fail if nil current",
+			current:     nil,
+			shouldError: true,
+		},
+		{
+			desc: "no conflicts with owned records",
+			current: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+					Owned:  true,
+				},
+			},
+			desired: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"2.2.2.2"},
+				},
+			},
+			expected: []string{},
+		},
+		{
+			desc: "no conflicts with equal unowned records",
+			current: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+			desired: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+			expected: []string{},
+		},
+		{
+			desc: "conflicts with different unowned records",
+			current: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+			desired: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record1",
+					Values: []string{"2.2.2.2"},
+				},
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "record2",
+					Values: []string{"2.2.2.2"},
+				},
+			},
+			expected: []string{"record1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			conflicts, err := FindConflicts(tc.current, tc.desired)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, len(tc.expected), len(conflicts))
+
+			for i, conflict := range conflicts {
+				assert.Equal(t, tc.expected[i], conflict.Name)
+			}
 		})
 	}
 }
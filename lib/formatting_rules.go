@@ -2,14 +2,17 @@ package lib
 
 import (
 	"io/ioutil"
+	"net/url"
 	"os"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
 func FormattingRulesFromYamlFile(file string) (rules []*FormattingRule, err error) {
-	finfo, err := os.Open(file)
+	configContent, err := ioutil.ReadFile(file)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err = errors.Wrapf(err,
@@ -19,24 +22,78 @@ func FormattingRulesFromYamlFile(file string) (rules []*FormattingRule, err erro
 		}
 
 		err = errors.Wrapf(err,
-			"unexpected error looking for config file %s",
+			"couldn't properly read config file %s",
 			file)
 		return
 	}
 
-	configContent, err := ioutil.ReadAll(finfo)
+	rules, err = FormattingRulesFromYaml(configContent)
 	if err != nil {
 		err = errors.Wrapf(err,
-			"couldn't properly read config file %s",
+			"couldn't properly parse yaml config file %s",
 			file)
 		return
 	}
 
-	err = yaml.Unmarshal(configContent, &rules)
+	return
+}
+
+// FormattingRulesFromYaml parses and validates
+// formatting rules from yaml content (see ParseConfig).
+func FormattingRulesFromYaml(content []byte) (rules []*FormattingRule, err error) {
+	rules, err = ParseConfig(content)
 	if err != nil {
 		err = errors.Wrapf(err,
-			"couldn't properly parse yaml config file %s",
-			file)
+			"couldn't properly parse yaml formatting rules")
+		return
+	}
+
+	return
+}
+
+// FormattingRulesFromS3 retrieves formatting rules
+// from an object in S3 referenced by an URI like
+// `s3://bucket/path/to/auto53.yaml`.
+func FormattingRulesFromS3(uri string) (rules []*FormattingRule, err error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || len(u.Path) < 2 {
+		err = errors.Errorf(
+			"malformed s3 uri %s - expected s3://bucket/key",
+			uri)
+		return
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create aws session")
+		return
+	}
+
+	result, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(u.Path[1:]),
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to retrieve config object %s",
+			uri)
+		return
+	}
+	defer result.Body.Close()
+
+	configContent, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't properly read config object %s",
+			uri)
+		return
+	}
+
+	rules, err = FormattingRulesFromYaml(configContent)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't properly parse yaml config object %s",
+			uri)
 		return
 	}
 
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/pkg/errors"
+)
+
+// Health checks created by auto53 are recognized by
+// their caller reference, which carries the owner that
+// created them:
+//
+//	auto53/default/10.0.0.2/bbc5eoh2ft4
+//
+// A health check is shared by every record set that
+// points to the same address with the same check
+// configuration, and deleted once no record set of a
+// full pass needs it anymore.
+const (
+	healthCheckReferencePrefix = "auto53/"
+
+	defaultHealthCheckRequestInterval  = 30
+	defaultHealthCheckFailureThreshold = 3
+)
+
+// healthCheckConfig creates the Route53 configuration
+// of the health check of `ip`, filling in the defaults.
+func healthCheckConfig(ip string, c *HealthCheckConfig) (config *route53.HealthCheckConfig) {
+	config = &route53.HealthCheckConfig{
+		IPAddress:        aws.String(ip),
+		Type:             aws.String(c.Type),
+		Port:             aws.Int64(c.Port),
+		RequestInterval:  aws.Int64(c.RequestInterval),
+		FailureThreshold: aws.Int64(c.FailureThreshold),
+	}
+
+	if c.RequestInterval == 0 {
+		config.RequestInterval = aws.Int64(defaultHealthCheckRequestInterval)
+	}
+
+	if c.FailureThreshold == 0 {
+		config.FailureThreshold = aws.Int64(defaultHealthCheckFailureThreshold)
+	}
+
+	if c.Type != route53.HealthCheckTypeTcp {
+		config.ResourcePath = aws.String(c.Path)
+		if c.Path == "" {
+			config.ResourcePath = aws.String("/")
+		}
+	}
+
+	return
+}
+
+// healthCheckKey identifies a health check by what it
+// checks so that existing checks can be reused.
+func healthCheckKey(config *route53.HealthCheckConfig) string {
+	return fmt.Sprintf("%s/%s/%d/%s/%d/%d",
+		aws.StringValue(config.IPAddress),
+		aws.StringValue(config.Type),
+		aws.Int64Value(config.Port),
+		aws.StringValue(config.ResourcePath),
+		aws.Int64Value(config.RequestInterval),
+		aws.Int64Value(config.FailureThreshold))
+}
+
+// usesHealthChecks indicates whether any of the rules
+// ties its records to health checks.
+func usesHealthChecks(rules []*FormattingRule) bool {
+	for _, rule := range rules {
+		if rule.HealthCheck != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listHealthChecks retrieves the health checks created
+// by this owner, going through all the pages.
+func (a *Auto) listHealthChecks() (checks []*route53.HealthCheck, err error) {
+	var (
+		input  = &route53.ListHealthChecksInput{}
+		result *route53.ListHealthChecksOutput
+		prefix = healthCheckReferencePrefix + a.owner + "/"
+	)
+
+	for {
+		err = a.callRoute53(func() (err error) {
+			result, err = a.route53.ListHealthChecks(input)
+			return
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list health checks")
+			return
+		}
+
+		for _, check := range result.HealthChecks {
+			if strings.HasPrefix(aws.StringValue(check.CallerReference), prefix) {
+				checks = append(checks, check)
+			}
+		}
+
+		if !aws.BoolValue(result.IsTruncated) {
+			break
+		}
+
+		input.Marker = result.NextMarker
+	}
+
+	return
+}
+
+// assignHealthChecks ties the desired records that
+// require health checks to existing ones, creating the
+// missing health checks unless `dry`.
+//
+// When `dry`, records whose health checks don't exist
+// yet are left without a health check.
+func (a *Auto) assignHealthChecks(records []*Record, checks []*route53.HealthCheck, dry bool) (err error) {
+	var existing = map[string]string{}
+
+	for _, check := range checks {
+		key := healthCheckKey(check.HealthCheckConfig)
+		if _, present := existing[key]; !present {
+			existing[key] = aws.StringValue(check.Id)
+		}
+	}
+
+	for _, record := range records {
+		if record.HealthCheck == nil {
+			continue
+		}
+
+		var (
+			config = healthCheckConfig(record.Values[0], record.HealthCheck)
+			key    = healthCheckKey(config)
+			result *route53.CreateHealthCheckOutput
+		)
+
+		id, present := existing[key]
+		if !present && !dry {
+			err = a.callRoute53(func() (err error) {
+				result, err = a.route53.CreateHealthCheck(&route53.CreateHealthCheckInput{
+					CallerReference: aws.String(healthCheckReferencePrefix +
+						a.owner + "/" + record.Values[0] + "/" +
+						strconv.FormatInt(time.Now().UnixNano(), 36)),
+					HealthCheckConfig: config,
+				})
+				return
+			})
+			if err != nil {
+				err = errors.Wrapf(err,
+					"failed to create health check for %s",
+					record.Values[0])
+				return
+			}
+
+			id = aws.StringValue(result.HealthCheck.Id)
+			existing[key] = id
+
+			a.logger.Info().
+				Str("health-check", id).
+				Str("ip", record.Values[0]).
+				Msg("health check created")
+		}
+
+		record.HealthCheckId = id
+	}
+
+	return
+}
+
+// deleteUnusedHealthChecks deletes the health checks
+// that none of the desired records are tied to.
+//
+// Health checks still referenced by record sets (e.g.,
+// of a zone that failed to be changed) are left for a
+// later pass.
+func (a *Auto) deleteUnusedHealthChecks(records []*Record, checks []*route53.HealthCheck) (err error) {
+	var (
+		used = map[string]bool{}
+		errs = MultiError{}
+	)
+
+	for _, record := range records {
+		if record.HealthCheckId != "" {
+			used[record.HealthCheckId] = true
+		}
+	}
+
+	for _, check := range checks {
+		id := aws.StringValue(check.Id)
+		if used[id] {
+			continue
+		}
+
+		err = a.callRoute53(func() (err error) {
+			_, err = a.route53.DeleteHealthCheck(&route53.DeleteHealthCheckInput{
+				HealthCheckId: check.Id,
+			})
+			return
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok &&
+				aerr.Code() == route53.ErrCodeHealthCheckInUse {
+				a.logger.Warn().
+					Str("health-check", id).
+					Msg("unused health check still referenced")
+				continue
+			}
+
+			errs[id] = err
+			continue
+		}
+
+		a.logger.Info().
+			Str("health-check", id).
+			Msg("health check deleted")
+	}
+
+	err = nil
+	if len(errs) != 0 {
+		err = errors.Wrapf(errs,
+			"failed to delete %d unused health checks",
+			len(errs))
+		return
+	}
+
+	return
+}
@@ -0,0 +1,316 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const lambdaRuntimeAPIVersion = "2018-06-01"
+
+// LambdaResult is the outcome of an invocation of
+// the lambda handler.
+type LambdaResult struct {
+
+	// Evaluations are the evaluations applied (or
+	// just computed, if Dry) in the invocation.
+	Evaluations []*Evaluation
+
+	// Conflicts are the desired records that couldn't
+	// be applied due to records not owned by auto53.
+	Conflicts []*Record
+
+	// Skipped are the instances left out of records
+	// for lacking the address that their rules pick.
+	Skipped []*SkippedInstance
+
+	// Results are the outcomes of the changes made
+	// to each zone.
+	Results []*ZoneResult
+
+	// Targets are the autoscaling groups that the
+	// invocation has been restricted to - empty for
+	// full passes.
+	Targets []string
+
+	Dry bool
+}
+
+// LambdaHandler performs a single reconciliation per
+// invocation.
+//
+// Invocations carrying autoscaling notifications (CloudWatch
+// events, SNS or SQS records) trigger passes restricted
+// to the autoscaling groups that they refer to, while any
+// other event (e.g., a CloudWatch schedule) triggers a
+// full pass.
+type LambdaHandler struct {
+	auto *Auto
+	dry  bool
+}
+
+type LambdaHandlerConfig struct {
+	Auto *Auto
+	Dry  bool
+}
+
+func NewLambdaHandler(cfg LambdaHandlerConfig) (h *LambdaHandler, err error) {
+	if cfg.Auto == nil {
+		err = errors.Errorf("Auto must be specified")
+		return
+	}
+
+	h = &LambdaHandler{
+		auto: cfg.Auto,
+		dry:  cfg.Dry,
+	}
+
+	return
+}
+
+// lambdaResponseMargin is the time left before the
+// deadline of an invocation to respond once done
+// waiting for changes to get INSYNC.
+const lambdaResponseMargin = 5 * time.Second
+
+func (h *LambdaHandler) Handle(ctx context.Context, event []byte) (result interface{}, err error) {
+	var (
+		targets = lambdaEventTargets(event)
+		auto    = h.auto
+		res     *Reconciliation
+	)
+
+	// waiting past the deadline would get the invocation
+	// killed without reporting the changes submitted.
+	deadline, ok := ctx.Deadline()
+	if ok {
+		auto = h.auto.withWaitDeadline(deadline.Add(-lambdaResponseMargin))
+	}
+
+	if len(targets) == 0 {
+		res, err = auto.Reconcile(h.dry)
+	} else {
+		res, err = auto.ReconcileAutoScalingGroups(h.dry, targets)
+	}
+
+	if err != nil {
+		return
+	}
+
+	result = &LambdaResult{
+		Evaluations: res.Evaluations,
+		Conflicts:   res.Conflicts,
+		Skipped:     res.Skipped,
+		Results:     res.Results,
+		Targets:     targets,
+		Dry:         h.dry,
+	}
+
+	return
+}
+
+// lambdaEventTargets retrieves the names of the autoscaling
+// groups referenced by the notifications in an event.
+func lambdaEventTargets(event []byte) (asgs []string) {
+	var (
+		envelope struct {
+			Records []struct {
+				Sns struct {
+					Message string
+				}
+				Body string `json:"body"`
+			}
+		}
+		names = map[string]bool{}
+	)
+
+	err := json.Unmarshal(event, &envelope)
+	if err != nil {
+		return
+	}
+
+	if len(envelope.Records) == 0 {
+		asg, _ := ParseAutoScalingNotification(string(event))
+		if asg != "" {
+			asgs = []string{asg}
+		}
+		return
+	}
+
+	for _, record := range envelope.Records {
+		var (
+			asg string
+			err error
+		)
+
+		switch {
+		case record.Sns.Message != "":
+			asg, err = ParseAutoScalingNotification(record.Sns.Message)
+		case record.Body != "":
+			asg, err = parseSQSMessage(record.Body)
+		}
+
+		if err == nil && asg != "" {
+			names[asg] = true
+		}
+	}
+
+	for name := range names {
+		asgs = append(asgs, name)
+	}
+
+	sort.Strings(asgs)
+	return
+}
+
+// LambdaRuntime is a client of the AWS Lambda runtime
+// API that makes it possible to run auto53 as a
+// custom runtime (`provided`) function.
+type LambdaRuntime struct {
+	logger   zerolog.Logger
+	endpoint string
+	client   *http.Client
+}
+
+// NewLambdaRuntime creates a client for the runtime API
+// served at `api` (as provided by the environment
+// variable AWS_LAMBDA_RUNTIME_API).
+func NewLambdaRuntime(api string) (r *LambdaRuntime, err error) {
+	if api == "" {
+		err = errors.Errorf("api must be specified")
+		return
+	}
+
+	r = &LambdaRuntime{
+		endpoint: "http://" + api + "/" + lambdaRuntimeAPIVersion,
+		client:   &http.Client{},
+	}
+
+	r.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "lambda").
+		Logger()
+
+	return
+}
+
+// Serve processes invocations with `handler` forever,
+// only returning when the runtime API can't be reached.
+func (r *LambdaRuntime) Serve(handler func(context.Context, []byte) (interface{}, error)) (err error) {
+	for {
+		var (
+			requestID string
+			deadline  time.Time
+			event     []byte
+			result    interface{}
+		)
+
+		requestID, deadline, event, err = r.next()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to retrieve next invocation")
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		result, err = handler(ctx, event)
+		cancel()
+
+		if err != nil {
+			r.logger.Error().
+				Err(err).
+				Str("request-id", requestID).
+				Msg("invocation failed")
+
+			err = r.post("/runtime/invocation/"+requestID+"/error",
+				lambdaError(err))
+		} else {
+			err = r.post("/runtime/invocation/"+requestID+"/response",
+				result)
+		}
+
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to report outcome of invocation %s",
+				requestID)
+			return
+		}
+	}
+}
+
+// InitError reports an error that prevents the function
+// from initializing.
+func (r *LambdaRuntime) InitError(initErr error) (err error) {
+	err = r.post("/runtime/init/error", lambdaError(initErr))
+	return
+}
+
+func (r *LambdaRuntime) next() (requestID string, deadline time.Time, event []byte, err error) {
+	resp, err := r.client.Get(r.endpoint + "/runtime/invocation/next")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("unexpected status %d", resp.StatusCode)
+		return
+	}
+
+	event, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	requestID = resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	if requestID == "" {
+		err = errors.Errorf("invocation without request id")
+		return
+	}
+
+	deadlineMs, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+	if err != nil {
+		err = errors.Wrapf(err, "malformed invocation deadline")
+		return
+	}
+
+	deadline = time.Unix(0, deadlineMs*int64(time.Millisecond))
+	return
+}
+
+func (r *LambdaRuntime) post(path string, body interface{}) (err error) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to encode body")
+		return
+	}
+
+	resp, err := r.client.Post(r.endpoint+path,
+		"application/json", bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		err = errors.Errorf("unexpected status %d", resp.StatusCode)
+		return
+	}
+
+	return
+}
+
+func lambdaError(err error) map[string]string {
+	return map[string]string{
+		"errorMessage": err.Error(),
+		"errorType":    "auto53.Error",
+	}
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLambdaEventTargets(t *testing.T) {
+	var testCases = []struct {
+		desc     string
+		event    string
+		expected []string
+	}{
+		{
+			desc:     "scheduled event",
+			event:    `{"source": "aws.events", "detail-type": "Scheduled Event", "detail": {}}`,
+			expected: nil,
+		},
+		{
+			desc:     "cloudwatch autoscaling event",
+			event:    `{"source": "aws.autoscaling", "detail": {"AutoScalingGroupName": "asg1"}}`,
+			expected: []string{"asg1"},
+		},
+		{
+			desc: "sns records",
+			event: `{"Records": [
+				{"Sns": {"Message": "{\"AutoScalingGroupName\": \"asg2\"}"}},
+				{"Sns": {"Message": "{\"AutoScalingGroupName\": \"asg1\"}"}},
+				{"Sns": {"Message": "{\"AutoScalingGroupName\": \"asg1\"}"}}
+			]}`,
+			expected: []string{"asg1", "asg2"},
+		},
+		{
+			desc:     "sqs records",
+			event:    `{"Records": [{"body": "{\"AutoScalingGroupName\": \"asg1\"}"}]}`,
+			expected: []string{"asg1"},
+		},
+		{
+			desc:     "not json",
+			event:    `foo`,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, lambdaEventTargets([]byte(tc.event)))
+		})
+	}
+}
+
+func TestLambdaRuntimeServe(t *testing.T) {
+	var (
+		invocations int
+		response    string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			invocations++
+			if invocations > 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			deadline := time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req1")
+			w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(deadline, 10))
+			w.Write([]byte(`{"foo": "bar"}`))
+		case "/2018-06-01/runtime/invocation/req1/response":
+			body, _ := ioutil.ReadAll(r.Body)
+			response = string(body)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	runtime, err := NewLambdaRuntime(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+
+	err = runtime.Serve(func(ctx context.Context, event []byte) (interface{}, error) {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+
+		return map[string]string{"event": string(event)}, nil
+	})
+	assert.Error(t, err)
+
+	assert.Equal(t, 2, invocations)
+	assert.Equal(t, `{"event":"{\"foo\": \"bar\"}"}`, response)
+}
+
+func TestLambdaHandlerWaitsUntilDeadline(t *testing.T) {
+	route53Client := &fakeRoute53{pendingPolls: 1000000}
+
+	a := newTestLoopAuto(route53Client)
+	a.waitInSync = true
+	a.waitTimeout = time.Hour
+	a.waitPollInterval = time.Millisecond
+
+	h, err := NewLambdaHandler(LambdaHandlerConfig{Auto: a})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		lambdaResponseMargin+50*time.Millisecond)
+	defer cancel()
+
+	_, err = h.Handle(ctx, []byte(`{}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not INSYNC")
+
+	// the changes got submitted and the invocation
+	// responds before its deadline.
+	assert.Len(t, route53Client.changes, 1)
+	assert.NoError(t, ctx.Err())
+	assert.Equal(t, time.Hour, a.waitTimeout)
+}
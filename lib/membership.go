@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxAutoScalingGroupNames is the maximum number
+	// of groups that a single DescribeAutoScalingGroups
+	// request describes.
+	maxAutoScalingGroupNames = 50
+
+	// maxInstanceStatusIds is the maximum number of
+	// instances that a single DescribeInstanceStatus
+	// request describes.
+	maxInstanceStatusIds = 100
+
+	instanceStatusOk = "ok"
+)
+
+// retrieveMembership fills the lifecycle state and the
+// health of the instances of the autoscaling groups
+// whose rules' membership policies require them.
+//
+// Lifecycle states come from the Auto Scaling API and
+// health from the EC2 status checks of running
+// instances.
+func (a *Auto) retrieveMembership(rules []*FormattingRule, asgsMap map[string]*AutoScalingGroup) (err error) {
+	var (
+		policy       MembershipPolicy
+		lifecycleMap = map[string]bool{}
+		healthMap    = map[string]bool{}
+		lifecycle    []string
+		health       []string
+	)
+
+	for _, rule := range rules {
+		policy, err = rule.MembershipPolicy()
+		if err != nil {
+			return
+		}
+
+		// patterns require the membership of every
+		// group that they match.
+		for name := range asgsMap {
+			if !rule.MatchesGroup(name) {
+				continue
+			}
+
+			switch policy {
+			case MembershipHealthy:
+				healthMap[name] = true
+				lifecycleMap[name] = true
+			case MembershipInService:
+				lifecycleMap[name] = true
+			}
+		}
+	}
+
+	for name := range lifecycleMap {
+		lifecycle = append(lifecycle, name)
+	}
+
+	for name := range healthMap {
+		for _, instance := range asgsMap[name].Instances {
+			if instance.Running {
+				health = append(health, instance.Id)
+			}
+		}
+	}
+
+	states, err := a.describeLifecycleStates(lifecycle)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve lifecycle states")
+		return
+	}
+
+	healthy, err := a.describeInstancesHealth(health)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve instances health")
+		return
+	}
+
+	for _, asg := range asgsMap {
+		for _, instance := range asg.Instances {
+			instance.LifecycleState = states[instance.Id]
+			instance.Healthy = healthy[instance.Id]
+		}
+	}
+
+	return
+}
+
+// describeLifecycleStates retrieves the lifecycle states
+// of the instances of the given autoscaling groups,
+// indexed by instance ID.
+func (a *Auto) describeLifecycleStates(names []string) (states map[string]string, err error) {
+	var (
+		batches = (len(names) + maxAutoScalingGroupNames - 1) / maxAutoScalingGroupNames
+		errs    = MultiError{}
+		mtx     sync.Mutex
+	)
+
+	states = map[string]string{}
+
+	parallelize(batches, a.concurrency, func(batch int) {
+		var (
+			start = batch * maxAutoScalingGroupNames
+			end   = start + maxAutoScalingGroupNames
+			input = &autoscaling.DescribeAutoScalingGroupsInput{}
+		)
+
+		if end > len(names) {
+			end = len(names)
+		}
+
+		input.AutoScalingGroupNames = aws.StringSlice(names[start:end])
+
+		for {
+			result, err := a.autoscaling.DescribeAutoScalingGroups(input)
+			if err != nil {
+				mtx.Lock()
+				errs[strings.Join(names[start:end], ",")] = err
+				mtx.Unlock()
+				return
+			}
+
+			mtx.Lock()
+			for _, group := range result.AutoScalingGroups {
+				for _, instance := range group.Instances {
+					states[aws.StringValue(instance.InstanceId)] =
+						aws.StringValue(instance.LifecycleState)
+				}
+			}
+			mtx.Unlock()
+
+			if aws.StringValue(result.NextToken) == "" {
+				return
+			}
+
+			input.NextToken = result.NextToken
+		}
+	})
+
+	if len(errs) != 0 {
+		err = errs
+		return
+	}
+
+	return
+}
+
+// describeInstancesHealth retrieves whether each of the
+// given running instances passes both EC2 status checks,
+// indexed by instance ID.
+func (a *Auto) describeInstancesHealth(ids []string) (healthy map[string]bool, err error) {
+	var (
+		batches = (len(ids) + maxInstanceStatusIds - 1) / maxInstanceStatusIds
+		errs    = MultiError{}
+		mtx     sync.Mutex
+	)
+
+	healthy = map[string]bool{}
+
+	parallelize(batches, a.concurrency, func(batch int) {
+		var (
+			start = batch * maxInstanceStatusIds
+			end   = start + maxInstanceStatusIds
+			input = &ec2.DescribeInstanceStatusInput{}
+		)
+
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		input.InstanceIds = aws.StringSlice(ids[start:end])
+
+		for {
+			result, err := a.ec2.DescribeInstanceStatus(input)
+			if err != nil {
+				mtx.Lock()
+				errs[strings.Join(ids[start:end], ",")] = err
+				mtx.Unlock()
+				return
+			}
+
+			mtx.Lock()
+			for _, status := range result.InstanceStatuses {
+				healthy[aws.StringValue(status.InstanceId)] =
+					status.InstanceStatus != nil &&
+						aws.StringValue(status.InstanceStatus.Status) == instanceStatusOk &&
+						status.SystemStatus != nil &&
+						aws.StringValue(status.SystemStatus.Status) == instanceStatusOk
+			}
+			mtx.Unlock()
+
+			if aws.StringValue(result.NextToken) == "" {
+				return
+			}
+
+			input.NextToken = result.NextToken
+		}
+	})
+
+	if len(errs) != 0 {
+		err = errs
+		return
+	}
+
+	return
+}
@@ -0,0 +1,337 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	snsNotification             = "Notification"
+	snsSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+
+	autoscalingTestNotification = "autoscaling:TEST_NOTIFICATION"
+)
+
+// SNSMessage is the envelope of the messages delivered
+// by SNS to HTTP endpoints and to SQS queues.
+type SNSMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string
+}
+
+// AutoScalingNotification holds the fields that matter
+// to auto53 from the messages that AWS AutoScaling
+// sends on scaling activities (notification
+// configurations), on lifecycle hooks and through
+// CloudWatch events.
+type AutoScalingNotification struct {
+	AutoScalingGroupName string
+	Event                string
+	LifecycleTransition  string
+	EC2InstanceId        string
+
+	// Detail is set by CloudWatch events.
+	Detail *AutoScalingNotification `json:"detail"`
+}
+
+// ParseAutoScalingNotification parses the body of an
+// autoscaling notification, retrieving the name of the
+// autoscaling group that it refers to.
+//
+// Test notifications (sent when notifications get
+// configured) result in an empty name.
+func ParseAutoScalingNotification(message string) (asg string, err error) {
+	var notification AutoScalingNotification
+
+	err = json.Unmarshal([]byte(message), &notification)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to parse autoscaling notification %s",
+			message)
+		return
+	}
+
+	if notification.Detail != nil {
+		notification = *notification.Detail
+	}
+
+	if notification.Event == autoscalingTestNotification {
+		return
+	}
+
+	if notification.AutoScalingGroupName == "" {
+		err = errors.Errorf(
+			"notification doesn't reference an autoscaling group %s",
+			message)
+		return
+	}
+
+	asg = notification.AutoScalingGroupName
+	return
+}
+
+var snsHostRegexp = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// validateSNSURL makes sure that `rawurl` points to
+// an SNS endpoint so that neither certificates nor
+// subscription confirmations are retrieved from
+// arbitrary places.
+func validateSNSURL(rawurl string) (err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		err = errors.Wrapf(err, "malformed url %s", rawurl)
+		return
+	}
+
+	if u.Scheme != "https" || !snsHostRegexp.MatchString(u.Host) {
+		err = errors.Errorf("url %s is not an SNS endpoint", rawurl)
+		return
+	}
+
+	return
+}
+
+const (
+	// snsCertificateTTL is how long a signing certificate
+	// is kept before being downloaded again.
+	snsCertificateTTL = time.Hour
+
+	// snsMaxCertificates bounds the number of signing
+	// certificates kept at once.
+	snsMaxCertificates = 16
+)
+
+// SNSVerifier verifies the signatures of SNS messages
+// against the certificates that SNS publishes.
+type SNSVerifier struct {
+	client *http.Client
+
+	certsMtx sync.Mutex
+	certs    map[string]*cachedCertificate
+
+	// fetchCertificate retrieves the certificate
+	// that signed a message.
+	fetchCertificate func(certURL string) (*x509.Certificate, error)
+}
+
+func NewSNSVerifier() (v *SNSVerifier) {
+	v = &SNSVerifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		certs:  map[string]*cachedCertificate{},
+	}
+
+	v.fetchCertificate = v.downloadCertificate
+	return
+}
+
+// Verify checks whether the message has been signed
+// by SNS.
+func (v *SNSVerifier) Verify(msg *SNSMessage) (err error) {
+	var (
+		hasher crypto.Hash
+		h      hash.Hash
+	)
+
+	switch msg.SignatureVersion {
+	case "1":
+		hasher, h = crypto.SHA1, sha1.New()
+	case "2":
+		hasher, h = crypto.SHA256, sha256.New()
+	default:
+		err = errors.Errorf(
+			"unsupported signature version %s",
+			msg.SignatureVersion)
+		return
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		err = errors.Wrapf(err, "malformed signature")
+		return
+	}
+
+	cert, err := v.certificate(msg.SigningCertURL)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve signing certificate")
+		return
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		err = errors.Errorf("signing certificate doesn't have an rsa key")
+		return
+	}
+
+	h.Write([]byte(snsStringToSign(msg)))
+
+	err = rsa.VerifyPKCS1v15(pub, hasher, h.Sum(nil), signature)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid signature")
+		return
+	}
+
+	return
+}
+
+type cachedCertificate struct {
+	cert    *x509.Certificate
+	fetched time.Time
+}
+
+// certificate retrieves the certificate at `certURL`,
+// downloading it if it hasn't been recently.
+//
+// The download happens outside of the lock so that a
+// slow one doesn't hold back messages signed with
+// certificates already known.
+func (v *SNSVerifier) certificate(certURL string) (cert *x509.Certificate, err error) {
+	var now = time.Now()
+
+	v.certsMtx.Lock()
+	cached, present := v.certs[certURL]
+	v.certsMtx.Unlock()
+
+	if present && now.Sub(cached.fetched) < snsCertificateTTL &&
+		now.Before(cached.cert.NotAfter) {
+		cert = cached.cert
+		return
+	}
+
+	cert, err = v.fetchCertificate(certURL)
+	if err != nil {
+		return
+	}
+
+	v.certsMtx.Lock()
+	defer v.certsMtx.Unlock()
+
+	v.certs[certURL] = &cachedCertificate{cert: cert, fetched: now}
+	v.evictCertificates()
+	return
+}
+
+// evictCertificates removes the oldest certificates
+// until the cache respects its bound.
+func (v *SNSVerifier) evictCertificates() {
+	for len(v.certs) > snsMaxCertificates {
+		var oldest string
+
+		for certURL, cached := range v.certs {
+			if oldest == "" || cached.fetched.Before(v.certs[oldest].fetched) {
+				oldest = certURL
+			}
+		}
+
+		delete(v.certs, oldest)
+	}
+}
+
+func (v *SNSVerifier) downloadCertificate(certURL string) (cert *x509.Certificate, err error) {
+	err = validateSNSURL(certURL)
+	if err != nil {
+		return
+	}
+
+	resp, err := v.client.Get(certURL)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to download certificate %s", certURL)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf(
+			"unexpected status %d downloading certificate %s",
+			resp.StatusCode, certURL)
+		return
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read certificate %s", certURL)
+		return
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		err = errors.Errorf("certificate %s is not pem-encoded", certURL)
+		return
+	}
+
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse certificate %s", certURL)
+		return
+	}
+
+	return
+}
+
+// snsStringToSign builds the canonical representation
+// of a message that SNS signs.
+func snsStringToSign(msg *SNSMessage) string {
+	var (
+		buf    = []string{}
+		fields [][2]string
+	)
+
+	switch msg.Type {
+	case snsNotification:
+		fields = [][2]string{
+			{"Message", msg.Message},
+			{"MessageId", msg.MessageId},
+			{"Subject", msg.Subject},
+			{"Timestamp", msg.Timestamp},
+			{"TopicArn", msg.TopicArn},
+			{"Type", msg.Type},
+		}
+	default:
+		fields = [][2]string{
+			{"Message", msg.Message},
+			{"MessageId", msg.MessageId},
+			{"SubscribeURL", msg.SubscribeURL},
+			{"Timestamp", msg.Timestamp},
+			{"Token", msg.Token},
+			{"TopicArn", msg.TopicArn},
+			{"Type", msg.Type},
+		}
+	}
+
+	for _, field := range fields {
+		// the subject is only part of the signed
+		// content when present
+		if field[0] == "Subject" && field[1] == "" {
+			continue
+		}
+
+		buf = append(buf, field[0], field[1])
+	}
+
+	return strings.Join(buf, "\n") + "\n"
+}
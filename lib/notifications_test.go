@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAutoScalingNotification(t *testing.T) {
+	var testCases = []struct {
+		desc        string
+		message     string
+		expected    string
+		shouldError bool
+	}{
+		{
+			desc:        "fail if not json",
+			message:     "foo",
+			shouldError: true,
+		},
+		{
+			desc:        "fail without autoscaling group",
+			message:     `{"Event": "autoscaling:EC2_INSTANCE_LAUNCH"}`,
+			shouldError: true,
+		},
+		{
+			desc:     "empty for test notifications",
+			message:  `{"AutoScalingGroupName": "asg1", "Event": "autoscaling:TEST_NOTIFICATION"}`,
+			expected: "",
+		},
+		{
+			desc:     "scaling activity",
+			message:  `{"AutoScalingGroupName": "asg1", "Event": "autoscaling:EC2_INSTANCE_TERMINATE"}`,
+			expected: "asg1",
+		},
+		{
+			desc:     "lifecycle hook",
+			message:  `{"AutoScalingGroupName": "asg1", "LifecycleTransition": "autoscaling:EC2_INSTANCE_LAUNCHING"}`,
+			expected: "asg1",
+		},
+		{
+			desc:     "cloudwatch event",
+			message:  `{"source": "aws.autoscaling", "detail": {"AutoScalingGroupName": "asg1"}}`,
+			expected: "asg1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			asg, err := ParseAutoScalingNotification(tc.message)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, asg)
+		})
+	}
+}
+
+func TestParseSQSMessage(t *testing.T) {
+	asg, err := parseSQSMessage(
+		`{"Type": "Notification", "Message": "{\"AutoScalingGroupName\": \"asg1\"}"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "asg1", asg)
+
+	asg, err = parseSQSMessage(`{"AutoScalingGroupName": "asg2"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "asg2", asg)
+}
+
+// newTestSNSVerifier creates a verifier trusting a newly
+// generated certificate, along with a function that signs
+// messages with its key.
+func newTestSNSVerifier(t *testing.T) (verifier *SNSVerifier, sign func(*SNSMessage)) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	verifier = NewSNSVerifier()
+	verifier.fetchCertificate = func(string) (*x509.Certificate, error) {
+		return cert, nil
+	}
+
+	sign = func(msg *SNSMessage) {
+		digest := sha256.Sum256([]byte(snsStringToSign(msg)))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+
+		msg.Signature = base64.StdEncoding.EncodeToString(signature)
+	}
+
+	return
+}
+
+func TestSNSVerifier(t *testing.T) {
+	verifier, sign := newTestSNSVerifier(t)
+
+	msg := &SNSMessage{
+		Type:             snsNotification,
+		MessageId:        "id1",
+		TopicArn:         "arn:aws:sns:us-east-1:123:topic",
+		Message:          `{"AutoScalingGroupName": "asg1"}`,
+		Timestamp:        "2017-12-10T09:19:58.485Z",
+		SignatureVersion: "2",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/cert.pem",
+	}
+
+	sign(msg)
+	assert.NoError(t, verifier.Verify(msg))
+
+	msg.Message = `{"AutoScalingGroupName": "asg2"}`
+	assert.Error(t, verifier.Verify(msg))
+
+	msg.SignatureVersion = "3"
+	assert.Error(t, verifier.Verify(msg))
+}
+
+func TestSNSVerifierCachesCertificates(t *testing.T) {
+	var (
+		fetched  = map[string]int{}
+		notAfter = time.Now().Add(time.Hour)
+		verifier = NewSNSVerifier()
+	)
+
+	verifier.fetchCertificate = func(certURL string) (*x509.Certificate, error) {
+		fetched[certURL]++
+		return &x509.Certificate{NotAfter: notAfter}, nil
+	}
+
+	certificate := func(certURL string) {
+		_, err := verifier.certificate(certURL)
+		require.NoError(t, err)
+	}
+
+	certificate("cert0")
+	certificate("cert0")
+	assert.Equal(t, 1, fetched["cert0"])
+
+	// stale certificates are downloaded again.
+	verifier.certs["cert0"].fetched = time.Now().Add(-snsCertificateTTL)
+	certificate("cert0")
+	assert.Equal(t, 2, fetched["cert0"])
+
+	// and so are the ones no longer valid.
+	notAfter = time.Now()
+	certificate("cert1")
+	certificate("cert1")
+	assert.Equal(t, 2, fetched["cert1"])
+
+	for i := 0; i < 2*snsMaxCertificates; i++ {
+		certificate(fmt.Sprintf("cert%d", i+2))
+	}
+	assert.Len(t, verifier.certs, snsMaxCertificates)
+}
+
+func TestValidateSNSURL(t *testing.T) {
+	assert.NoError(t, validateSNSURL("https://sns.us-east-1.amazonaws.com/cert.pem"))
+	assert.NoError(t, validateSNSURL("https://sns.cn-north-1.amazonaws.com.cn/cert.pem"))
+	assert.Error(t, validateSNSURL("http://sns.us-east-1.amazonaws.com/cert.pem"))
+	assert.Error(t, validateSNSURL("https://sns.us-east-1.amazonaws.com.evil.com/cert.pem"))
+	assert.Error(t, validateSNSURL("https://example.com/cert.pem"))
+}
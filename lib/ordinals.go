@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+// ordinalTag is the instance tag that holds the slot
+// allocated to the instance within its autoscaling
+// group.
+const ordinalTag = "auto53:ordinal"
+
+// parseOrdinal retrieves the slot held in the ordinal
+// tag of an instance, or 0 if it doesn't hold a valid
+// one.
+func parseOrdinal(tags map[string]string) (ordinal int) {
+	ordinal, err := strconv.Atoi(tags[ordinalTag])
+	if err != nil || ordinal < 1 {
+		ordinal = 0
+	}
+
+	return
+}
+
+// allocateOrdinals allocates slots to the running
+// instances of the autoscaling groups of rules with
+// Ordinals, persisting new allocations as instance tags
+// (unless `dry`) so that they survive passes and
+// restarts.
+func (a *Auto) allocateOrdinals(rules []*FormattingRule, asgsMap map[string]*AutoScalingGroup, dry bool) (err error) {
+	var (
+		groups   = map[string]bool{}
+		assigned []*Instance
+	)
+
+	for _, rule := range rules {
+		if !rule.Ordinals {
+			continue
+		}
+
+		if rule.AutoScalingGroup == "" {
+			err = errors.Errorf(
+				"rule for record '%s' requires an AutoScalingGroup for Ordinals",
+				rule.Record)
+			return
+		}
+
+		for name := range asgsMap {
+			if rule.MatchesGroup(name) {
+				groups[name] = true
+			}
+		}
+	}
+
+	for name := range groups {
+		assigned = append(assigned, assignOrdinals(asgsMap[name].Instances)...)
+	}
+
+	if dry || len(assigned) == 0 {
+		return
+	}
+
+	var (
+		errs = MultiError{}
+		mtx  sync.Mutex
+	)
+
+	parallelize(len(assigned), a.concurrency, func(job int) {
+		var instance = assigned[job]
+
+		_, err := a.ec2.CreateTags(&ec2.CreateTagsInput{
+			Resources: aws.StringSlice([]string{instance.Id}),
+			Tags: []*ec2.Tag{
+				{
+					Key:   aws.String(ordinalTag),
+					Value: aws.String(strconv.Itoa(instance.Ordinal)),
+				},
+			},
+		})
+		if err != nil {
+			mtx.Lock()
+			errs[instance.Id] = err
+			mtx.Unlock()
+			return
+		}
+
+		a.logger.Info().
+			Str("instance", instance.Id).
+			Str("autoscaling-group", instance.AutoScalingGroup).
+			Int("ordinal", instance.Ordinal).
+			Msg("ordinal allocated")
+	})
+
+	if len(errs) != 0 {
+		err = errors.Wrapf(errs, "failed to tag instances with their ordinals")
+		return
+	}
+
+	return
+}
+
+// assignOrdinals gives the running instances of a group
+// that don't hold a slot the lowest free ones, starting
+// at 1, retrieving them.
+//
+// Instances that aren't running release their slots.
+// Given that instances are ordered by launch time, the
+// oldest instance keeps a slot held by several of them.
+func assignOrdinals(instances []*Instance) (assigned []*Instance) {
+	var taken = map[int]bool{}
+
+	for _, instance := range instances {
+		if !instance.Running {
+			continue
+		}
+
+		if instance.Ordinal != 0 && !taken[instance.Ordinal] {
+			taken[instance.Ordinal] = true
+			continue
+		}
+
+		assigned = append(assigned, instance)
+	}
+
+	var slot = 1
+
+	for _, instance := range assigned {
+		for taken[slot] {
+			slot++
+		}
+
+		instance.Ordinal = slot
+		taken[slot] = true
+	}
+
+	return
+}
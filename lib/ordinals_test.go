@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignOrdinals(t *testing.T) {
+	var testCases = []struct {
+		desc      string
+		instances []*Instance
+		expected  map[string]int
+		assigned  []string
+	}{
+		{
+			desc: "slots in launch order",
+			instances: []*Instance{
+				{Id: "i-1", Running: true},
+				{Id: "i-2", Running: true},
+			},
+			expected: map[string]int{"i-1": 1, "i-2": 2},
+			assigned: []string{"i-1", "i-2"},
+		},
+		{
+			desc: "allocated slots are kept and the lowest free one is used",
+			instances: []*Instance{
+				{Id: "i-1", Running: true, Ordinal: 3},
+				{Id: "i-2", Running: true, Ordinal: 1},
+				{Id: "i-3", Running: true},
+			},
+			expected: map[string]int{"i-1": 3, "i-2": 1, "i-3": 2},
+			assigned: []string{"i-3"},
+		},
+		{
+			desc: "instances that aren't running release their slots",
+			instances: []*Instance{
+				{Id: "i-1", Ordinal: 1},
+				{Id: "i-2", Running: true},
+			},
+			expected: map[string]int{"i-1": 1, "i-2": 1},
+			assigned: []string{"i-2"},
+		},
+		{
+			desc: "the oldest instance keeps a duplicated slot",
+			instances: []*Instance{
+				{Id: "i-1", Running: true, Ordinal: 1},
+				{Id: "i-2", Running: true, Ordinal: 1},
+			},
+			expected: map[string]int{"i-1": 1, "i-2": 2},
+			assigned: []string{"i-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var (
+				assigned = []string{}
+				ordinals = map[string]int{}
+			)
+
+			for _, instance := range assignOrdinals(tc.instances) {
+				assigned = append(assigned, instance.Id)
+			}
+
+			for _, instance := range tc.instances {
+				ordinals[instance.Id] = instance.Ordinal
+			}
+
+			assert.Equal(t, tc.assigned, assigned)
+			assert.Equal(t, tc.expected, ordinals)
+		})
+	}
+}
+
+func TestAllocateOrdinals(t *testing.T) {
+	var (
+		ec2Client = &fakeEC2{}
+		rules     = []*FormattingRule{
+			{AutoScalingGroup: "workers-*", Ordinals: true, Record: "worker-{{ .Ordinal }}"},
+			{AutoScalingGroup: "api", Record: "api"},
+		}
+		newGroups = func() map[string]*AutoScalingGroup {
+			return map[string]*AutoScalingGroup{
+				"workers-1": {
+					Name: "workers-1",
+					Instances: []*Instance{
+						{Id: "i-1", Running: true, Ordinal: parseOrdinal(map[string]string{ordinalTag: "2"})},
+						{Id: "i-2", Running: true},
+					},
+				},
+				"api": {
+					Name:      "api",
+					Instances: []*Instance{{Id: "i-3", Running: true}},
+				},
+			}
+		}
+	)
+
+	a := newTestAuto(rules)
+	a.ec2 = ec2Client
+
+	for _, rule := range rules {
+		require.NoError(t, rule.ParseGroupPattern())
+	}
+
+	asgs := newGroups()
+	require.NoError(t, a.allocateOrdinals(rules, asgs, true))
+	assert.Equal(t, 1, asgs["workers-1"].Instances[1].Ordinal)
+	assert.Empty(t, ec2Client.tags)
+
+	asgs = newGroups()
+	require.NoError(t, a.allocateOrdinals(rules, asgs, false))
+	assert.Equal(t, map[string]map[string]string{
+		"i-2": {ordinalTag: "1"},
+	}, ec2Client.tags)
+	assert.Equal(t, 0, asgs["api"].Instances[0].Ordinal)
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Ownership of records is tracked by companion TXT
+// records (similar to what external-dns does) that
+// live next to the records that auto53 creates:
+//
+//	_auto53-a.worker.internal.  TXT  "heritage=auto53,auto53/owner=default"
+//	worker.internal.            A    10.0.0.2 10.0.0.3
+//
+// Records without a TXT record carrying the owner
+// of the running instance are left untouched.
+const (
+	ownershipPrefix   = "_auto53-"
+	ownershipHeritage = "heritage=auto53"
+	ownershipOwnerKey = "auto53/owner="
+	DefaultOwner      = "default"
+)
+
+// ownershipRecordName retrieves the name of the TXT
+// record that marks the ownership of the record
+// `name` of type `recordType`.
+func ownershipRecordName(name, recordType string) string {
+	return ownershipPrefix + strings.ToLower(recordType) + "." + name
+}
+
+// ownershipValue retrieves the (quoted) value of
+// the TXT record that marks records as owned by
+// `owner`.
+func ownershipValue(owner string) string {
+	return strconv.Quote(ownershipHeritage + "," + ownershipOwnerKey + owner)
+}
+
+// parseOwnershipRecordName extracts the name and type of
+// the record owned as marked by the ownership TXT record
+// `name` (as listed by Route53, possibly escaped).
+func parseOwnershipRecordName(name string) (owned, recordType string, ok bool) {
+	name = decodeDNSName(name)
+
+	if !strings.HasPrefix(name, ownershipPrefix) {
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, ownershipPrefix), ".", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	owned = parts[1]
+	recordType = strings.ToUpper(parts[0])
+	ok = true
+	return
+}
+
+// ownershipChange creates a change that adds or removes
+// the ownership TXT record of `record`.
+func ownershipChange(action, owner string, record *Record) (change *route53.Change) {
+	change = &route53.Change{
+		Action: aws.String(action),
+		ResourceRecordSet: &route53.ResourceRecordSet{
+			Name: aws.String(
+				ownershipRecordName(record.Name, record.Type) +
+					"." + record.Zone.Name + "."),
+			Type: aws.String("TXT"),
+			ResourceRecords: []*route53.ResourceRecord{
+				{Value: aws.String(ownershipValue(owner))},
+			},
+			TTL: aws.Int64(300),
+		},
+	}
+
+	// each record set of a name gets its own ownership
+	// record, distinguished by the same identifier.
+	if record.SetIdentifier != "" {
+		change.ResourceRecordSet.SetIdentifier = aws.String(record.SetIdentifier)
+		change.ResourceRecordSet.MultiValueAnswer = aws.Bool(true)
+	}
+
+	return
+}
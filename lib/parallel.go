@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrency is the default maximum number
+// of concurrent requests made to each AWS service.
+const DefaultConcurrency = 4
+
+// parallelize calls `fn` for each of the `n` jobs with
+// at most `concurrency` of them running at the same
+// time, waiting for all of them to finish.
+func parallelize(n, concurrency int, fn func(job int)) {
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+	)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if concurrency > n {
+		concurrency = n
+	}
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				fn(job)
+			}
+		}()
+	}
+
+	for job := 0; job < n; job++ {
+		jobs <- job
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+// MultiError aggregates errors that happened while
+// processing independent items (e.g., zones),
+// indexed by the item.
+type MultiError map[string]error
+
+func (m MultiError) Error() string {
+	var (
+		keys = make([]string, 0, len(m))
+		msgs = make([]string, 0, len(m))
+	)
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		msgs = append(msgs, key+": "+m[key].Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
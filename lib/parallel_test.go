@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelize(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		running int
+		peak    int
+		done    = map[int]bool{}
+	)
+
+	parallelize(20, 3, func(job int) {
+		mtx.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mtx.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mtx.Lock()
+		running--
+		done[job] = true
+		mtx.Unlock()
+	})
+
+	assert.Len(t, done, 20)
+	assert.True(t, peak <= 3)
+
+	parallelize(0, 3, func(job int) {
+		t.Fatal("no job should run")
+	})
+}
+
+func TestMultiError(t *testing.T) {
+	err := MultiError{
+		"zone2": errors.New("err2"),
+		"zone1": errors.New("err1"),
+	}
+
+	assert.Equal(t, "zone1: err1; zone2: err2", err.Error())
+}
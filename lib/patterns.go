@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IsPattern indicates whether an autoscaling group name
+// of a rule is a pattern rather than a literal name:
+// either a regular expression enclosed in slashes (e.g.,
+// `/^api-(\d+)-(blue|green)$/`) or a glob with `*` or
+// `?` wildcards (e.g., `api-*-blue`).
+func IsPattern(name string) bool {
+	return isRegexpPattern(name) || strings.ContainsAny(name, "*?")
+}
+
+func isRegexpPattern(name string) bool {
+	return len(name) > 1 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/")
+}
+
+// compilePattern compiles an autoscaling group name
+// pattern into a regular expression that must match the
+// whole name.
+//
+// Each wildcard of a glob is a capture group, so that
+// `api-*-blue` captures what `*` stands for.
+func compilePattern(pattern string) (expr *regexp.Regexp, err error) {
+	var source string
+
+	if isRegexpPattern(pattern) {
+		source = `^(?:` + pattern[1:len(pattern)-1] + `)$`
+	} else {
+		source = globExpression(pattern)
+	}
+
+	expr, err = regexp.Compile(source)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to compile autoscaling group pattern '%s'",
+			pattern)
+		return
+	}
+
+	return
+}
+
+// globExpression converts a glob in which `*` matches
+// any sequence of characters and `?` any single
+// character into an anchored regular expression that
+// captures each of them.
+func globExpression(glob string) string {
+	var expr = regexp.QuoteMeta(glob)
+
+	expr = strings.Replace(expr, `\*`, `(.*)`, -1)
+	expr = strings.Replace(expr, `\?`, `(.)`, -1)
+
+	return `^` + expr + `$`
+}
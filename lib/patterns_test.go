@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilePattern(t *testing.T) {
+	var testCases = []struct {
+		desc        string
+		pattern     string
+		name        string
+		expected    []string
+		shouldError bool
+	}{
+		{
+			desc:     "glob captures its wildcards",
+			pattern:  "api-*-blue",
+			name:     "api-20260912-blue",
+			expected: []string{"api-20260912-blue", "20260912"},
+		},
+		{
+			desc:     "glob must match the whole name",
+			pattern:  "api-*",
+			name:     "old-api-1",
+			expected: nil,
+		},
+		{
+			desc:     "glob escapes other characters",
+			pattern:  "api.?",
+			name:     "api-1",
+			expected: nil,
+		},
+		{
+			desc:     "regexp captures its groups",
+			pattern:  `/api-(\d+)-(blue|green)/`,
+			name:     "api-20260912-green",
+			expected: []string{"api-20260912-green", "20260912", "green"},
+		},
+		{
+			desc:     "regexp is anchored",
+			pattern:  `/api-\d+/`,
+			name:     "api-1-blue",
+			expected: nil,
+		},
+		{
+			desc:        "invalid regexp",
+			pattern:     `/api-(/`,
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.True(t, IsPattern(tc.pattern))
+
+			expr, err := compilePattern(tc.pattern)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, expr.FindStringSubmatch(tc.name))
+		})
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	assert.False(t, IsPattern("api-20260912-blue"))
+	assert.False(t, IsPattern("/"))
+	assert.True(t, IsPattern("api-*"))
+	assert.True(t, IsPattern("/^api$/"))
+}
@@ -0,0 +1,333 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Reconciler periodically drives an Auto instance
+// towards the desired state, surviving failures
+// of individual passes.
+type Reconciler struct {
+	logger   zerolog.Logger
+	auto     *Auto
+	interval time.Duration
+	dry      bool
+	triggers chan chan Status
+	wakeup   chan struct{}
+
+	pendingMtx sync.Mutex
+	pending    map[string]bool
+
+	statusMtx sync.RWMutex
+	status    Status
+}
+
+// Status summarizes the outcome of the latest
+// reconciliation passes.
+type Status struct {
+
+	// Reconciliation is the result of the last
+	// successful full pass.
+	Reconciliation *Reconciliation
+
+	// TargetedReconciliation is the result of the last
+	// successful pass restricted to the autoscaling
+	// groups of notifications (see Enqueue), if any.
+	TargetedReconciliation *Reconciliation
+
+	// LastError is the error message of the last
+	// pass if it failed, empty otherwise.
+	LastError string
+
+	// LastAttempt is the time at which the last
+	// pass started.
+	LastAttempt time.Time
+
+	// LastSuccess is the time at which the last
+	// successful pass started.
+	LastSuccess time.Time
+
+	// Dry indicates whether evaluations are only
+	// computed and not executed.
+	Dry bool
+
+	// ConfigError is the error message of the last
+	// reload of the formatting rules if it failed (the
+	// previous rules being kept), empty otherwise.
+	ConfigError string
+
+	// LastReload is the time at which the formatting
+	// rules were last reloaded successfully.
+	LastReload time.Time
+}
+
+type ReconcilerConfig struct {
+	Auto     *Auto
+	Interval time.Duration
+	Dry      bool
+}
+
+func NewReconciler(cfg ReconcilerConfig) (r *Reconciler, err error) {
+	if cfg.Auto == nil {
+		err = errors.Errorf("Auto must be specified")
+		return
+	}
+
+	if cfg.Interval <= 0 {
+		err = errors.Errorf(
+			"Interval must be positive - %s provided",
+			cfg.Interval)
+		return
+	}
+
+	r = &Reconciler{}
+	r.auto = cfg.Auto
+	r.interval = cfg.Interval
+	r.dry = cfg.Dry
+	r.triggers = make(chan chan Status)
+	r.wakeup = make(chan struct{}, 1)
+	r.pending = map[string]bool{}
+	r.status.Dry = cfg.Dry
+	r.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "reconciler").
+		Logger()
+
+	return
+}
+
+// Run performs a reconciliation pass right away and
+// then once every interval until the context gets
+// cancelled.
+// Passes requested via Trigger and Enqueue are performed
+// in between the periodic ones.
+//
+// A pass that is in progress when the context is
+// cancelled is allowed to finish so that no batch
+// of changes is left half-submitted.
+func (r *Reconciler) Run(ctx context.Context) {
+	var ticker = time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.reconcile()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info().Msg("stopping reconciliation loop")
+			return
+		case <-ticker.C:
+			r.reconcile()
+		case reply := <-r.triggers:
+			r.logger.Info().Msg("reconciliation triggered")
+			r.reconcile()
+			reply <- r.Status()
+		case <-r.wakeup:
+			r.reconcileAutoScalingGroups(r.takePending())
+		}
+	}
+}
+
+// Enqueue schedules a pass targeting the given
+// autoscaling groups (see Auto.ReconcileAutoScalingGroups)
+// without waiting for it.
+//
+// Groups enqueued while a pass is in progress are
+// coalesced into a single pass.
+func (r *Reconciler) Enqueue(asgs ...string) {
+	if len(asgs) == 0 {
+		return
+	}
+
+	r.pendingMtx.Lock()
+	for _, asg := range asgs {
+		r.pending[asg] = true
+	}
+	r.pendingMtx.Unlock()
+
+	select {
+	case r.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Reconciler) takePending() (asgs []string) {
+	r.pendingMtx.Lock()
+	defer r.pendingMtx.Unlock()
+
+	for asg := range r.pending {
+		asgs = append(asgs, asg)
+	}
+
+	sort.Strings(asgs)
+	r.pending = map[string]bool{}
+	return
+}
+
+// Trigger requests an immediate reconciliation pass
+// outside of the periodic schedule, blocking until
+// it finishes and returning the resulting status.
+func (r *Reconciler) Trigger(ctx context.Context) (status Status, err error) {
+	var reply = make(chan Status, 1)
+
+	select {
+	case r.triggers <- reply:
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(),
+			"reconciler didn't accept the trigger")
+		return
+	}
+
+	select {
+	case status = <-reply:
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(),
+			"reconciliation didn't finish in time")
+	}
+
+	return
+}
+
+// Status retrieves the outcome of the latest
+// reconciliation passes.
+func (r *Reconciler) Status() Status {
+	r.statusMtx.RLock()
+	defer r.statusMtx.RUnlock()
+
+	return r.status
+}
+
+// ReloadFormattingRules replaces the formatting rules
+// of the passes with the ones retrieved by `load`,
+// keeping the previous ones if they can't be loaded
+// (e.g., if they're invalid).
+//
+// The outcome is logged and exposed in the status.
+func (r *Reconciler) ReloadFormattingRules(load func() ([]*FormattingRule, error)) (err error) {
+	rules, err := load()
+	if err == nil {
+		err = r.auto.SetFormattingRules(rules)
+	}
+
+	r.statusMtx.Lock()
+	if err != nil {
+		r.status.ConfigError = err.Error()
+	} else {
+		r.status.ConfigError = ""
+		r.status.LastReload = time.Now()
+	}
+	r.statusMtx.Unlock()
+
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Msg("failed to reload formatting rules - keeping the previous ones")
+		return
+	}
+
+	r.logger.Info().
+		Int("rules", len(rules)).
+		Msg("formatting rules reloaded")
+	return
+}
+
+func (r *Reconciler) reconcile() {
+	var start = time.Now()
+
+	res, err := r.auto.Reconcile(r.dry)
+	r.record(start, res, err)
+}
+
+func (r *Reconciler) reconcileAutoScalingGroups(asgs []string) {
+	if len(asgs) == 0 {
+		return
+	}
+
+	r.logger.Info().
+		Strs("autoscaling-groups", asgs).
+		Msg("targeted reconciliation triggered")
+
+	var start = time.Now()
+
+	res, err := r.auto.ReconcileAutoScalingGroups(r.dry, asgs)
+	r.record(start, res, err)
+}
+
+// record updates the status with the outcome of a
+// pass that has just finished, logging it.
+func (r *Reconciler) record(start time.Time, res *Reconciliation, err error) {
+	r.statusMtx.Lock()
+	r.status.LastAttempt = start
+	if err != nil {
+		r.status.LastError = err.Error()
+	} else {
+		r.status.LastError = ""
+		r.status.LastSuccess = start
+
+		// targeted passes only cover some groups, so they
+		// don't replace the picture of the full ones.
+		if len(res.Targets) == 0 {
+			r.status.Reconciliation = res
+		} else {
+			r.status.TargetedReconciliation = res
+		}
+	}
+	r.statusMtx.Unlock()
+
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Dur("duration", time.Since(start)).
+			Msg("reconciliation failed")
+		return
+	}
+
+	for _, conflict := range res.Conflicts {
+		r.logger.Warn().
+			Str("record", conflict.Name).
+			Str("zone", conflict.Zone.Name).
+			Msg("record name taken by a record not owned by auto53")
+	}
+
+	for _, eval := range res.Evaluations {
+		event := r.logger.Info().
+			Str("type", eval.Type.String()).
+			Str("record", eval.Record.Name).
+			Str("zone", eval.Record.Zone.Name).
+			Strs("values", recordValues(eval.Record)).
+			Bool("dry", r.dry)
+
+		if eval.Record.SetIdentifier != "" {
+			event = event.Str("set", eval.Record.SetIdentifier)
+		}
+
+		if eval.Previous != nil {
+			event = event.Strs("previous-values", recordValues(eval.Previous))
+		}
+
+		event.Msg("evaluation")
+	}
+
+	for _, result := range res.Results {
+		for _, change := range result.Changes {
+			r.logger.Info().
+				Str("zone", result.Zone).
+				Str("change", change.ID).
+				Str("status", change.Status).
+				Dur("propagation", change.Duration).
+				Msg("change submitted")
+		}
+	}
+
+	r.logger.Info().
+		Int("evaluations", len(res.Evaluations)).
+		Dur("duration", time.Since(start)).
+		Msg("reconciliation finished")
+}
@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLoopAuto creates an Auto whose passes list the
+// records of a single zone from the given fake.
+func newTestLoopAuto(route53Client *fakeRoute53) *Auto {
+	route53Client.recordSets = []*route53.ResourceRecordSet{
+		newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+	}
+
+	a := newTestAuto([]*FormattingRule{
+		{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "web"},
+	})
+	a.route53 = route53Client
+	a.ec2 = &fakeEC2{
+		pages: [][]*ec2.Instance{
+			{newTestInstance("i-1", "asg1", "10.0.0.1")},
+		},
+	}
+
+	return a
+}
+
+func setListError(f *fakeRoute53, zone string, err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.listErrors = map[string]error{zone: err}
+}
+
+func listCalls(f *fakeRoute53) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.calls
+}
+
+// runReconciler runs the reconciler in the background,
+// returning a channel closed once Run returns.
+func runReconciler(ctx context.Context, r *Reconciler) (done chan struct{}) {
+	done = make(chan struct{})
+
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	return
+}
+
+func TestReconcilerRunSurvivesFailures(t *testing.T) {
+	var (
+		route53Client = &fakeRoute53{}
+		ctx, cancel   = context.WithTimeout(context.Background(), 10*time.Second)
+	)
+	defer cancel()
+
+	setListError(route53Client, "zone1",
+		awserr.New(route53.ErrCodeNoSuchHostedZone, "no such zone", nil))
+
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(route53Client),
+		Interval: time.Hour,
+		Dry:      true,
+	})
+	require.NoError(t, err)
+
+	done := runReconciler(ctx, r)
+
+	status, err := r.Trigger(ctx)
+	require.NoError(t, err)
+
+	assert.Contains(t, status.LastError, "no such zone")
+	assert.Nil(t, status.Reconciliation)
+	assert.False(t, status.LastAttempt.IsZero())
+	assert.True(t, status.LastSuccess.IsZero())
+
+	setListError(route53Client, "zone1", nil)
+
+	status, err = r.Trigger(ctx)
+	require.NoError(t, err)
+
+	assert.Empty(t, status.LastError)
+	require.NotNil(t, status.Reconciliation)
+	assert.Len(t, status.Reconciliation.Evaluations, 1)
+	assert.Equal(t, status.LastAttempt, status.LastSuccess)
+
+	var succeeded = status
+
+	setListError(route53Client, "zone1",
+		awserr.New(route53.ErrCodeNoSuchHostedZone, "no such zone", nil))
+
+	// the loop keeps serving passes after a failure and
+	// the result of the last successful one is kept.
+	status, err = r.Trigger(ctx)
+	require.NoError(t, err)
+
+	assert.Contains(t, status.LastError, "no such zone")
+	assert.True(t, succeeded.Reconciliation == status.Reconciliation)
+	assert.Equal(t, succeeded.LastSuccess, status.LastSuccess)
+	assert.True(t, status.LastAttempt.After(succeeded.LastAttempt))
+
+	cancel()
+	<-done
+}
+
+func TestReconcilerRunTicks(t *testing.T) {
+	var (
+		route53Client = &fakeRoute53{}
+		ctx, cancel   = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(route53Client),
+		Interval: 10 * time.Millisecond,
+		Dry:      true,
+	})
+	require.NoError(t, err)
+
+	done := runReconciler(ctx, r)
+
+	// the first pass is performed right away and the
+	// following ones once every interval.
+	deadline := time.Now().Add(10 * time.Second)
+	for listCalls(route53Client) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.True(t, listCalls(route53Client) >= 3)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run didn't return after the context got cancelled")
+	}
+
+	calls := listCalls(route53Client)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, calls, listCalls(route53Client))
+
+	_, err = r.Trigger(ctx)
+	assert.Error(t, err)
+}
+
+func TestReconcilerEnqueueCoalesces(t *testing.T) {
+	var (
+		route53Client = &fakeRoute53{}
+		ctx, cancel   = context.WithTimeout(context.Background(), 10*time.Second)
+		a             = newTestLoopAuto(route53Client)
+	)
+	defer cancel()
+
+	require.NoError(t, a.SetFormattingRules([]*FormattingRule{
+		{AutoScalingGroup: "asg1", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "web"},
+		{AutoScalingGroup: "asg2", Zone: Zone{ID: "zone1", Name: "apex1"}, Record: "api"},
+	}))
+
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     a,
+		Interval: time.Hour,
+		Dry:      true,
+	})
+	require.NoError(t, err)
+
+	// notifications received while a pass is in
+	// progress (here, before the loop starts).
+	r.Enqueue("asg2")
+	r.Enqueue("asg1")
+	r.Enqueue("asg2")
+
+	done := runReconciler(ctx, r)
+
+	targeted := func() bool {
+		return r.Status().TargetedReconciliation != nil
+	}
+
+	for !targeted() && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	require.True(t, targeted())
+
+	status := r.Status()
+	assert.Equal(t, []string{"asg1", "asg2"}, status.TargetedReconciliation.Targets)
+
+	// the result of the full pass is kept.
+	require.NotNil(t, status.Reconciliation)
+	assert.Empty(t, status.Reconciliation.Targets)
+	assert.Len(t, status.Reconciliation.AutoScalingGroups, 2)
+
+	// a full pass followed by a single targeted one.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, listCalls(route53Client))
+
+	cancel()
+	<-done
+}
@@ -1,15 +1,49 @@
 package lib
 
 import (
+	"reflect"
+	"sort"
+
 	"github.com/pkg/errors"
 )
 
 // CreateRecords takes autoscalinggroup state and
 // a set of formatting rules to produce a desired
 // records state.
-// TODO possibly take privateIp instead of
-//	publicIp
+//
+// Rules with reverse zones also produce the PTR
+// records of the addresses of their A and AAAA records.
+//
+// Only the instances admitted by the membership
+// policy of each rule are included. Rules with health
+// checks produce a multivalue answer record set per
+// instance.
+//
+// Instances lacking the address picked by a rule are
+// left out of its records of that type (e.g., an IPv4-only
+// instance only takes part in the A records of a rule
+// producing both A and AAAA ones).
+//
+// The records are returned sorted by zone and name
+// so that consecutive passes produce the same output.
 func CreateRecords(asgs map[string]*AutoScalingGroup, rules []*FormattingRule) (records []*Record, err error) {
+	records, _, err = createRecords(asgs, rules)
+	return
+}
+
+// SkippedInstance is an instance left out of the
+// records of a given type of a rule.
+type SkippedInstance struct {
+	Instance string
+	Record   string
+	Type     string
+	Reason   string
+}
+
+// createRecords creates the desired records (see
+// CreateRecords), also retrieving the instances that
+// have been left out of them.
+func createRecords(asgs map[string]*AutoScalingGroup, rules []*FormattingRule) (records []*Record, skipped []*SkippedInstance, err error) {
 	if asgs == nil || rules == nil {
 		err = errors.Errorf("asgs and rules must be non-nil")
 		return
@@ -17,49 +51,159 @@ func CreateRecords(asgs map[string]*AutoScalingGroup, rules []*FormattingRule) (
 
 	var (
 		recordsMap      = map[string]*Record{}
-		ruleAsg         string
-		asg             *AutoScalingGroup
-		present         bool
-		fqdn            string
+		groups          []*AutoScalingGroup
+		record          *Record
+		reverseRecord   *Record
+		recordTypes     []string
 		templatedRecord string
+		value           string
+		admitted        bool
 	)
 
 	records = make([]*Record, 0)
 
 	for _, rule := range rules {
-		ruleAsg = rule.AutoScalingGroup
-
 		err = rule.ParseRecordTemplate()
 		if err != nil {
 			err = errors.Wrapf(err, "failed to initialize record template")
 			return
 		}
 
-		asg, present = asgs[ruleAsg]
-		if !present {
-			err = errors.Errorf("couldn't find asg %s for rule", ruleAsg)
+		if rule.HealthCheck != nil {
+			err = rule.HealthCheck.Validate()
+			if err != nil {
+				err = errors.Wrapf(err,
+					"invalid health check for record '%s'",
+					rule.Record)
+				return
+			}
+		}
+
+		recordTypes, err = rule.RecordTypes()
+		if err != nil {
 			return
 		}
 
-		for _, instance := range asg.Instances {
+		if rule.Selector != nil {
+			err = rule.Selector.Validate()
+			if err != nil {
+				err = errors.Wrapf(err,
+					"invalid selector for record '%s'",
+					rule.Record)
+				return
+			}
+		}
+
+		if rule.Reverse != nil {
+			err = rule.Reverse.Validate()
+			if err != nil {
+				err = errors.Wrapf(err,
+					"invalid reverse zones for record '%s'",
+					rule.Record)
+				return
+			}
+		}
+
+		groups, err = ruleGroups(asgs, rule)
+		if err != nil {
+			return
+		}
+
+		for _, instance := range groupsInstances(groups) {
+			admitted, err = rule.Admits(instance)
+			if err != nil {
+				return
+			}
+
+			if !admitted {
+				continue
+			}
+
 			templatedRecord, err = rule.TemplateRecord(instance)
 			if err != nil {
 				err = errors.Wrapf(err, "failed to template record")
 				return
 			}
 
-			fqdn = templatedRecord + "." + rule.Zone.Name
-
-			existingRecord, present := recordsMap[fqdn]
-			if present {
-				existingRecord.IPs = append(existingRecord.IPs,
-					instance.PublicIp)
-			} else {
-				recordsMap[fqdn] = &Record{
-					Zone: rule.Zone,
-					Name: templatedRecord,
-					IPs:  []string{instance.PublicIp},
+			for _, recordType := range recordTypes {
+				value, err = rule.InstanceValue(instance, recordType)
+				if missing, ok := err.(*missingAddressError); ok {
+					skipped = append(skipped, &SkippedInstance{
+						Instance: instance.Id,
+						Record:   templatedRecord,
+						Type:     recordType,
+						Reason:   missing.Error(),
+					})
+					err = nil
+					continue
+				}
+
+				if err != nil {
+					err = errors.Wrapf(err,
+						"failed to pick value for record '%s'",
+						templatedRecord)
+					return
+				}
+
+				if value != "" && (recordType == "A" || recordType == "AAAA") {
+					reverseRecord, err = rule.ReverseRecord(templatedRecord, value)
+					if err != nil {
+						return
+					}
+
+					if reverseRecord != nil {
+						existingRecord, present := recordsMap[reverseRecord.Key()]
+						if !present {
+							recordsMap[reverseRecord.Key()] = reverseRecord
+						} else if !containsString(existingRecord.Values, reverseRecord.Values[0]) {
+							existingRecord.Values = append(existingRecord.Values,
+								reverseRecord.Values[0])
+						}
+					}
+				}
+
+				record, err = rule.NewRecord(templatedRecord, recordType, instance)
+				if err != nil {
+					return
+				}
+
+				existingRecord, present := recordsMap[record.Key()]
+				if !present {
+					recordsMap[record.Key()] = record
+					existingRecord = record
+				} else if !sameRecordAttributes(existingRecord, record) {
+					err = errors.Errorf(
+						"rules producing record '%s' disagree on its TTL, routing policy or alias",
+						templatedRecord)
+					return
 				}
+
+				// aliases have no values, while multivalue
+				// answer record sets point to a single
+				// instance so that Route53 can leave them
+				// out of the answers when unhealthy.
+				if value == "" ||
+					existingRecord.MultiValueAnswer && len(existingRecord.Values) > 0 {
+					continue
+				}
+
+				if containsString(existingRecord.Values, value) {
+					continue
+				}
+
+				// CNAMEs can only point to a single name.
+				if recordType == "CNAME" && len(existingRecord.Values) > 0 {
+					if existingRecord.Values[0] != value {
+						err = errors.Errorf(
+							"CNAME record '%s' would point to both %s and %s",
+							templatedRecord, existingRecord.Values[0], value)
+						return
+					}
+
+					continue
+				}
+
+				existingRecord.Values = append(existingRecord.Values, value)
 			}
 		}
 	}
@@ -68,5 +212,85 @@ func CreateRecords(asgs map[string]*AutoScalingGroup, rules []*FormattingRule) (
 		records = append(records, record)
 	}
 
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Zone.ID != records[j].Zone.ID {
+			return records[i].Zone.ID < records[j].Zone.ID
+		}
+
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+
+		if records[i].Type != records[j].Type {
+			return records[i].Type < records[j].Type
+		}
+
+		return records[i].SetIdentifier < records[j].SetIdentifier
+	})
+
 	return
 }
+
+// ruleGroups retrieves the groups of instances that the
+// rule selects from. Patterns might match no group at
+// all, while named groups must be present.
+func ruleGroups(asgs map[string]*AutoScalingGroup, rule *FormattingRule) (groups []*AutoScalingGroup, err error) {
+	if !IsPattern(rule.AutoScalingGroup) {
+		asg, present := asgs[rule.GroupName()]
+		if !present {
+			err = errors.Errorf("couldn't find asg %s for rule", rule.GroupName())
+			return
+		}
+
+		groups = []*AutoScalingGroup{asg}
+		return
+	}
+
+	for name, asg := range asgs {
+		if rule.MatchesGroup(name) {
+			groups = append(groups, asg)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	return
+}
+
+// groupsInstances retrieves the instances of all the
+// groups.
+func groupsInstances(groups []*AutoScalingGroup) (instances []*Instance) {
+	for _, group := range groups {
+		instances = append(instances, group.Instances...)
+	}
+
+	return
+}
+
+// sameRecordAttributes indicates whether two records
+// agree on everything but their values.
+func sameRecordAttributes(a, b *Record) bool {
+	if (a.Weight == nil) != (b.Weight == nil) ||
+		a.Weight != nil && *a.Weight != *b.Weight {
+		return false
+	}
+
+	return a.TTL == b.TTL &&
+		a.Region == b.Region &&
+		a.Failover == b.Failover &&
+		a.MultiValueAnswer == b.MultiValueAnswer &&
+		reflect.DeepEqual(a.HealthCheck, b.HealthCheck) &&
+		reflect.DeepEqual(a.Alias, b.Alias)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
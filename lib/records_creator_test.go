@@ -3,6 +3,7 @@ package lib
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,8 +27,9 @@ func TestCreateRecords(t *testing.T) {
 					Name: "asg1",
 					Instances: []*Instance{
 						{
-							Id:       "inst1",
-							PublicIp: "1.1.1.1",
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "1.1.1.1",
 						},
 					},
 				},
@@ -49,7 +51,7 @@ func TestCreateRecords(t *testing.T) {
 						ID:   "zone123",
 					},
 					Name: "aaa",
-					IPs: []string{
+					Values: []string{
 						"1.1.1.1",
 					},
 				},
@@ -63,12 +65,14 @@ func TestCreateRecords(t *testing.T) {
 					Name: "asg1",
 					Instances: []*Instance{
 						{
-							Id:       "inst1",
-							PublicIp: "1.1.1.1",
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "1.1.1.1",
 						},
 						{
-							Id:       "inst2",
-							PublicIp: "1.1.1.2",
+							Id:        "inst2",
+							Running:   true,
+							PrivateIp: "1.1.1.2",
 						},
 					},
 				},
@@ -90,7 +94,7 @@ func TestCreateRecords(t *testing.T) {
 						ID:   "zone123",
 					},
 					Name: "aaa",
-					IPs: []string{
+					Values: []string{
 						"1.1.1.1",
 						"1.1.1.2",
 					},
@@ -105,12 +109,14 @@ func TestCreateRecords(t *testing.T) {
 					Name: "asg1",
 					Instances: []*Instance{
 						{
-							Id:       "inst1",
-							PublicIp: "1.1.1.1",
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "1.1.1.1",
 						},
 						{
-							Id:       "inst2",
-							PublicIp: "1.1.1.2",
+							Id:        "inst2",
+							Running:   true,
+							PrivateIp: "1.1.1.2",
 						},
 					},
 				},
@@ -118,12 +124,14 @@ func TestCreateRecords(t *testing.T) {
 					Name: "asg2",
 					Instances: []*Instance{
 						{
-							Id:       "vvvv1",
-							PublicIp: "2.2.2.1",
+							Id:        "vvvv1",
+							Running:   true,
+							PrivateIp: "2.2.2.1",
 						},
 						{
-							Id:       "vvvv2",
-							PublicIp: "2.2.2.2",
+							Id:        "vvvv2",
+							Running:   true,
+							PrivateIp: "2.2.2.2",
 						},
 					},
 				},
@@ -153,7 +161,7 @@ func TestCreateRecords(t *testing.T) {
 						ID:   "zone123",
 					},
 					Name: "aaa",
-					IPs: []string{
+					Values: []string{
 						"1.1.1.1",
 						"1.1.1.2",
 						"2.2.2.1",
@@ -170,12 +178,14 @@ func TestCreateRecords(t *testing.T) {
 					Name: "asg1",
 					Instances: []*Instance{
 						{
-							Id:       "inst1",
-							PublicIp: "1.1.1.1",
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "1.1.1.1",
 						},
 						{
-							Id:       "inst2",
-							PublicIp: "1.1.1.2",
+							Id:        "inst2",
+							Running:   true,
+							PrivateIp: "1.1.1.2",
 						},
 					},
 				},
@@ -197,7 +207,7 @@ func TestCreateRecords(t *testing.T) {
 						ID:   "zone123",
 					},
 					Name: "inst1-asg1",
-					IPs: []string{
+					Values: []string{
 						"1.1.1.1",
 					},
 				},
@@ -207,19 +217,143 @@ func TestCreateRecords(t *testing.T) {
 						ID:   "zone123",
 					},
 					Name: "inst2-asg1",
-					IPs: []string{
+					Values: []string{
 						"1.1.1.2",
 					},
 				},
 			},
 			shouldError: false,
 		},
+		{
+			desc: "public address",
+			asgs: map[string]*AutoScalingGroup{
+				"asg1": {
+					Name: "asg1",
+					Instances: []*Instance{
+						{
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "10.0.0.1",
+							PublicIp:  "1.1.1.1",
+						},
+					},
+				},
+			},
+			rules: []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Public:           true,
+				},
+			},
+			expected: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "aaa",
+					Values: []string{"1.1.1.1"},
+				},
+			},
+		},
+		{
+			desc: "secondary address",
+			asgs: map[string]*AutoScalingGroup{
+				"asg1": {
+					Name: "asg1",
+					Instances: []*Instance{
+						{
+							Id:          "inst1",
+							Running:     true,
+							PrivateIp:   "10.0.0.1",
+							SecondaryIp: "10.0.1.1",
+						},
+					},
+				},
+			},
+			rules: []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Address:          AddressSecondary,
+				},
+			},
+			expected: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "aaa",
+					Values: []string{"10.0.1.1"},
+				},
+			},
+		},
+		{
+			desc: "missing public address should leave instance out",
+			asgs: map[string]*AutoScalingGroup{
+				"asg1": {
+					Name: "asg1",
+					Instances: []*Instance{
+						{
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "10.0.0.1",
+						},
+						{
+							Id:        "inst2",
+							Running:   true,
+							PrivateIp: "10.0.0.2",
+							PublicIp:  "1.1.1.2",
+						},
+					},
+				},
+			},
+			rules: []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Address:          AddressPublic,
+				},
+			},
+			expected: []*Record{
+				{
+					Zone:   Zone{Name: "apex1", ID: "zone123"},
+					Name:   "aaa",
+					Values: []string{"1.1.1.2"},
+				},
+			},
+		},
+		{
+			desc: "conflicting public and address should fail",
+			asgs: map[string]*AutoScalingGroup{
+				"asg1": {
+					Name: "asg1",
+					Instances: []*Instance{
+						{
+							Id:        "inst1",
+							Running:   true,
+							PrivateIp: "10.0.0.1",
+							PublicIp:  "1.1.1.1",
+						},
+					},
+				},
+			},
+			rules: []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Public:           true,
+					Address:          AddressPrivate,
+				},
+			},
+			shouldError: true,
+		},
 	}
 
 	var (
 		records        []*Record
 		expectedRecord *Record
-		expectedIP     string
+		expectedValue  string
 		err            error
 	)
 
@@ -239,15 +373,744 @@ func TestCreateRecords(t *testing.T) {
 
 				assert.Equal(t, expectedRecord.Name, actualRecord.Name)
 				assert.Equal(t, expectedRecord.Zone, actualRecord.Zone)
-				assert.Equal(t, len(expectedRecord.IPs), len(actualRecord.IPs))
+				assert.Equal(t, len(expectedRecord.Values), len(actualRecord.Values))
 
-				for k, actualIP := range actualRecord.IPs {
-					expectedIP = expectedRecord.IPs[k]
+				for k, actualValue := range actualRecord.Values {
+					expectedValue = expectedRecord.Values[k]
 
-					assert.Equal(t, expectedIP, actualIP)
+					assert.Equal(t, expectedValue, actualValue)
 				}
 			}
 
 		})
 	}
 }
+
+func TestCreateRecordsMembership(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{
+					Id:        "stopped",
+					PrivateIp: "10.0.0.1",
+				},
+				{
+					Id:             "pending",
+					PrivateIp:      "10.0.0.2",
+					Running:        true,
+					LifecycleState: "Pending",
+				},
+				{
+					Id:             "unhealthy",
+					PrivateIp:      "10.0.0.3",
+					Running:        true,
+					LifecycleState: "InService",
+				},
+				{
+					Id:             "healthy",
+					PrivateIp:      "10.0.0.4",
+					Running:        true,
+					LifecycleState: "InService",
+					Healthy:        true,
+				},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		membership  MembershipPolicy
+		expected    []string
+		shouldError bool
+	}{
+		{
+			desc:     "running by default",
+			expected: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"},
+		},
+		{
+			desc:       "in-service",
+			membership: MembershipInService,
+			expected:   []string{"10.0.0.3", "10.0.0.4"},
+		},
+		{
+			desc:       "healthy",
+			membership: MembershipHealthy,
+			expected:   []string{"10.0.0.4"},
+		},
+		{
+			desc:        "unknown should fail",
+			membership:  "foo",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			records, err := CreateRecords(asgs, []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Membership:       tc.membership,
+				},
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, tc.expected, records[0].Values)
+		})
+	}
+}
+
+func TestCreateRecordsRouting(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateIp: "10.0.0.1", Running: true},
+				{Id: "inst2", PrivateIp: "10.0.0.2", Running: true},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		ttl         int64
+		routing     *RoutingPolicy
+		expected    []*Record
+		shouldError bool
+	}{
+		{
+			desc: "simple with default ttl",
+			expected: []*Record{
+				{
+					Name:   "aaa",
+					Type:   "A",
+					TTL:    DefaultTTL,
+					Values: []string{"10.0.0.1", "10.0.0.2"},
+				},
+			},
+		},
+		{
+			desc: "weighted",
+			ttl:  60,
+			routing: &RoutingPolicy{
+				Type:          RoutingWeighted,
+				SetIdentifier: "blue",
+				Weight:        10,
+			},
+			expected: []*Record{
+				{
+					Name:          "aaa",
+					Type:          "A",
+					TTL:           60,
+					Values:        []string{"10.0.0.1", "10.0.0.2"},
+					SetIdentifier: "blue",
+					Weight:        aws.Int64(10),
+				},
+			},
+		},
+		{
+			desc: "latency",
+			routing: &RoutingPolicy{
+				Type:          RoutingLatency,
+				SetIdentifier: "us",
+				Region:        "us-east-1",
+			},
+			expected: []*Record{
+				{
+					Name:          "aaa",
+					Type:          "A",
+					TTL:           DefaultTTL,
+					Values:        []string{"10.0.0.1", "10.0.0.2"},
+					SetIdentifier: "us",
+					Region:        "us-east-1",
+				},
+			},
+		},
+		{
+			desc: "multivalue",
+			routing: &RoutingPolicy{
+				Type: RoutingMultiValue,
+			},
+			expected: []*Record{
+				{
+					Name:             "aaa",
+					Type:             "A",
+					TTL:              DefaultTTL,
+					Values:           []string{"10.0.0.1"},
+					SetIdentifier:    "inst1",
+					MultiValueAnswer: true,
+				},
+				{
+					Name:             "aaa",
+					Type:             "A",
+					TTL:              DefaultTTL,
+					Values:           []string{"10.0.0.2"},
+					SetIdentifier:    "inst2",
+					MultiValueAnswer: true,
+				},
+			},
+		},
+		{
+			desc: "failover",
+			routing: &RoutingPolicy{
+				Type:          RoutingFailover,
+				SetIdentifier: "primary",
+				Failover:      "PRIMARY",
+			},
+			expected: []*Record{
+				{
+					Name:          "aaa",
+					Type:          "A",
+					TTL:           DefaultTTL,
+					Values:        []string{"10.0.0.1", "10.0.0.2"},
+					SetIdentifier: "primary",
+					Failover:      "PRIMARY",
+				},
+			},
+		},
+		{
+			desc: "weighted without set identifier should fail",
+			routing: &RoutingPolicy{
+				Type:   RoutingWeighted,
+				Weight: 10,
+			},
+			shouldError: true,
+		},
+		{
+			desc: "failover without role should fail",
+			routing: &RoutingPolicy{
+				Type:          RoutingFailover,
+				SetIdentifier: "primary",
+			},
+			shouldError: true,
+		},
+		{
+			desc:        "unknown should fail",
+			routing:     &RoutingPolicy{Type: "foo"},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			records, err := CreateRecords(asgs, []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					TTL:              tc.ttl,
+					Routing:          tc.routing,
+				},
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, records, len(tc.expected))
+
+			for i, expected := range tc.expected {
+				expected.Zone = Zone{Name: "apex1", ID: "zone123"}
+				assert.Equal(t, expected, records[i])
+			}
+		})
+	}
+}
+
+func TestCreateRecordsDisagreeingRules(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateIp: "10.0.0.1", Running: true},
+			},
+		},
+	}
+
+	_, err := CreateRecords(asgs, []*FormattingRule{
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "aaa",
+			TTL:              60,
+		},
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "aaa",
+			TTL:              120,
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateRecordsTypes(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateIp: "10.0.0.1", Ipv6Ip: "2600:1f18::1", Running: true},
+				{Id: "inst2", PrivateIp: "10.0.0.2", Ipv6Ip: "2600:1f18::2", Running: true},
+			},
+		},
+		"asg2": {
+			Name: "asg2",
+			Instances: []*Instance{
+				{Id: "inst3", PrivateIp: "10.0.0.3", Running: true},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		asg         string
+		recordType  RecordType
+		expected    map[string][]string
+		shouldError bool
+	}{
+		{
+			desc:     "A by default",
+			asg:      "asg1",
+			expected: map[string][]string{"A": {"10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			desc:       "AAAA",
+			asg:        "asg1",
+			recordType: RecordTypeAAAA,
+			expected:   map[string][]string{"AAAA": {"2600:1f18::1", "2600:1f18::2"}},
+		},
+		{
+			desc:       "both",
+			asg:        "asg1",
+			recordType: RecordTypeBoth,
+			expected: map[string][]string{
+				"A":    {"10.0.0.1", "10.0.0.2"},
+				"AAAA": {"2600:1f18::1", "2600:1f18::2"},
+			},
+		},
+		{
+			desc:       "AAAA without ipv6 address should leave instance out",
+			asg:        "asg2",
+			recordType: RecordTypeAAAA,
+			expected:   map[string][]string{},
+		},
+		{
+			desc:       "both without ipv6 address should leave instance out of AAAA",
+			asg:        "asg2",
+			recordType: RecordTypeBoth,
+			expected:   map[string][]string{"A": {"10.0.0.3"}},
+		},
+		{
+			desc:        "unknown should fail",
+			asg:         "asg1",
+			recordType:  "MX",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			records, err := CreateRecords(asgs, []*FormattingRule{
+				{
+					AutoScalingGroup: tc.asg,
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "aaa",
+					Type:             tc.recordType,
+				},
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			actual := map[string][]string{}
+			for _, record := range records {
+				actual[record.Type] = record.Values
+			}
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestCreateRecordsSkipsInstances(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateIp: "10.0.0.1", Running: true},
+				{Id: "inst2", PrivateIp: "10.0.0.2", PublicIp: "1.1.1.2", Running: true},
+			},
+		},
+	}
+
+	records, skipped, err := createRecords(asgs, []*FormattingRule{
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "public",
+			Address:          AddressPublic,
+		},
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "private",
+		},
+	})
+	require.NoError(t, err)
+
+	// the instance still takes part in the records of
+	// the other rules.
+	require.Len(t, records, 2)
+	assert.Equal(t, "private", records[0].Name)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, records[0].Values)
+	assert.Equal(t, "public", records[1].Name)
+	assert.Equal(t, []string{"1.1.1.2"}, records[1].Values)
+
+	assert.Equal(t, []*SkippedInstance{
+		{
+			Instance: "inst1",
+			Record:   "public",
+			Type:     "A",
+			Reason:   "instance inst1 doesn't have a public address",
+		},
+	}, skipped)
+}
+
+func TestCreateRecordsTargets(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateDnsName: "ip-10-0-0-1.ec2.internal", Running: true},
+				{Id: "inst2", PrivateDnsName: "ip-10-0-0-2.ec2.internal", Running: true},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		rule        *FormattingRule
+		expected    []*Record
+		shouldError bool
+	}{
+		{
+			desc: "cname per instance",
+			rule: &FormattingRule{
+				Record: "{{ .Id }}",
+				Type:   RecordTypeCNAME,
+				Target: "{{ .PrivateDnsName }}",
+			},
+			expected: []*Record{
+				{
+					Name:   "inst1",
+					Type:   "CNAME",
+					TTL:    DefaultTTL,
+					Values: []string{"ip-10-0-0-1.ec2.internal."},
+				},
+				{
+					Name:   "inst2",
+					Type:   "CNAME",
+					TTL:    DefaultTTL,
+					Values: []string{"ip-10-0-0-2.ec2.internal."},
+				},
+			},
+		},
+		{
+			desc: "cname to a single target",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Type:   RecordTypeCNAME,
+				Target: "Lb.Example.Com.",
+			},
+			expected: []*Record{
+				{
+					Name:   "aaa",
+					Type:   "CNAME",
+					TTL:    DefaultTTL,
+					Values: []string{"lb.example.com."},
+				},
+			},
+		},
+		{
+			desc: "cname to several targets should fail",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Type:   RecordTypeCNAME,
+				Target: "{{ .PrivateDnsName }}",
+			},
+			shouldError: true,
+		},
+		{
+			desc: "cname without target should fail",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Type:   RecordTypeCNAME,
+			},
+			shouldError: true,
+		},
+		{
+			desc: "alias to a load balancer",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Target: "my-alb-123.us-east-1.elb.amazonaws.com",
+				Alias: &AliasConfig{
+					HostedZoneId:         "Z35SXDOTRQ7X7K",
+					EvaluateTargetHealth: true,
+				},
+			},
+			expected: []*Record{
+				{
+					Name:   "aaa",
+					Type:   "A",
+					Values: []string{},
+					Alias: &AliasTarget{
+						DNSName:              "my-alb-123.us-east-1.elb.amazonaws.com.",
+						HostedZoneId:         "Z35SXDOTRQ7X7K",
+						EvaluateTargetHealth: true,
+					},
+				},
+			},
+		},
+		{
+			desc: "alias to another record of the zone",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Type:   RecordTypeBoth,
+				Target: "bbb.apex1",
+				Alias:  &AliasConfig{},
+			},
+			expected: []*Record{
+				{
+					Name:   "aaa",
+					Type:   "A",
+					Values: []string{},
+					Alias:  &AliasTarget{DNSName: "bbb.apex1.", HostedZoneId: "zone123"},
+				},
+				{
+					Name:   "aaa",
+					Type:   "AAAA",
+					Values: []string{},
+					Alias:  &AliasTarget{DNSName: "bbb.apex1.", HostedZoneId: "zone123"},
+				},
+			},
+		},
+		{
+			desc: "target without cname or alias should fail",
+			rule: &FormattingRule{
+				Record: "aaa",
+				Target: "bbb.apex1",
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.rule.AutoScalingGroup = "asg1"
+			tc.rule.Zone = Zone{Name: "apex1", ID: "zone123"}
+
+			records, err := CreateRecords(asgs, []*FormattingRule{tc.rule})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, records, len(tc.expected))
+
+			for i, expected := range tc.expected {
+				expected.Zone = tc.rule.Zone
+				assert.Equal(t, expected, records[i])
+			}
+		})
+	}
+}
+
+func TestCreateRecordsSRV(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"asg1": {
+			Name: "asg1",
+			Instances: []*Instance{
+				{Id: "inst1", Running: true, Tags: map[string]string{"port": "8080"}},
+				{Id: "inst2", Running: true, Tags: map[string]string{"port": "8081"}},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		srv         *SRVConfig
+		expected    []string
+		shouldError bool
+	}{
+		{
+			desc: "port from tags",
+			srv: &SRVConfig{
+				Priority: "10",
+				Weight:   "5",
+				Port:     `{{ index .Tags "port" }}`,
+			},
+			expected: []string{
+				"10 5 8080 inst1.workers.apex1.",
+				"10 5 8081 inst2.workers.apex1.",
+			},
+		},
+		{
+			desc: "default priority and weight",
+			srv: &SRVConfig{
+				Port: "80",
+			},
+			expected: []string{
+				"0 0 80 inst1.workers.apex1.",
+				"0 0 80 inst2.workers.apex1.",
+			},
+		},
+		{
+			desc: "missing port should fail",
+			srv: &SRVConfig{
+				Port: `{{ index .Tags "missing" }}`,
+			},
+			shouldError: true,
+		},
+		{
+			desc: "out of range port should fail",
+			srv: &SRVConfig{
+				Port: "65536",
+			},
+			shouldError: true,
+		},
+		{
+			desc:        "missing configuration should fail",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			records, err := CreateRecords(asgs, []*FormattingRule{
+				{
+					AutoScalingGroup: "asg1",
+					Zone:             Zone{Name: "apex1", ID: "zone123"},
+					Record:           "_http._tcp.api",
+					Type:             RecordTypeSRV,
+					Target:           "{{ .Id }}.workers.apex1",
+					SRV:              tc.srv,
+				},
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, "_http._tcp.api", records[0].Name)
+			assert.Equal(t, "SRV", records[0].Type)
+			assert.Equal(t, tc.expected, records[0].Values)
+		})
+	}
+}
+
+func TestCreateRecordsReverse(t *testing.T) {
+	var (
+		asgs = map[string]*AutoScalingGroup{
+			"asg1": {
+				Name: "asg1",
+				Instances: []*Instance{
+					{Id: "inst1", PrivateIp: "10.0.0.1", Ipv6Ip: "2600:1f18::1", Running: true},
+					{Id: "inst2", PrivateIp: "10.0.1.2", Ipv6Ip: "2600:1f18::2", Running: true},
+					{Id: "inst3", PrivateIp: "192.168.0.3", Ipv6Ip: "2600:1f18::3", Running: true},
+				},
+			},
+		}
+		ipv4Zone = Zone{Name: "10.in-addr.arpa", ID: "zone4"}
+		ipv6Zone = Zone{Name: "8.1.f.1.0.0.6.2.ip6.arpa", ID: "zone6"}
+	)
+
+	records, err := CreateRecords(asgs, []*FormattingRule{
+		{
+			AutoScalingGroup: "asg1",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "{{ .Id }}",
+			Type:             RecordTypeBoth,
+			Reverse: &ReverseConfig{
+				IPv4: &ipv4Zone,
+				IPv6: &ipv6Zone,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	var ptrs = map[string][]string{}
+	for _, record := range records {
+		if record.Type == "PTR" {
+			ptrs[record.Zone.ID+"/"+record.Name] = record.Values
+		}
+	}
+
+	assert.Equal(t, map[string][]string{
+		"zone4/1.0.0": {"inst1.apex1."},
+		"zone4/2.1.0": {"inst2.apex1."},
+		"zone6/1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": {"inst1.apex1."},
+		"zone6/2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": {"inst2.apex1."},
+		"zone6/3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": {"inst3.apex1."},
+	}, ptrs)
+}
+
+func TestCreateRecordsGroupPatterns(t *testing.T) {
+	var asgs = map[string]*AutoScalingGroup{
+		"api-20260912-blue": {
+			Name: "api-20260912-blue",
+			Instances: []*Instance{
+				{Id: "inst1", PrivateIp: "10.0.0.1", AutoScalingGroup: "api-20260912-blue", Running: true},
+			},
+		},
+		"api-20260913-green": {
+			Name: "api-20260913-green",
+			Instances: []*Instance{
+				{Id: "inst2", PrivateIp: "10.0.0.2", AutoScalingGroup: "api-20260913-green", Running: true},
+			},
+		},
+	}
+
+	records, err := CreateRecords(asgs, []*FormattingRule{
+		{
+			AutoScalingGroup: `/^api-(?P<date>\d+)-(blue|green)$/`,
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "{{ index .Match 2 }}-{{ .Captures.date }}",
+		},
+		{
+			AutoScalingGroup: "api-*",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "api",
+		},
+		{
+			AutoScalingGroup: "workers-*",
+			Zone:             Zone{Name: "apex1", ID: "zone123"},
+			Record:           "workers",
+		},
+	})
+	require.NoError(t, err)
+
+	var values = map[string][]string{}
+	for _, record := range records {
+		values[record.Name] = record.Values
+	}
+
+	assert.Equal(t, map[string][]string{
+		"api":            {"10.0.0.1", "10.0.0.2"},
+		"blue-20260912":  {"10.0.0.1"},
+		"green-20260913": {"10.0.0.2"},
+	}, values)
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Limits imposed by Route53 on a single
+// ChangeResourceRecordSets request.
+//
+// UPSERT changes count twice towards both.
+// See http://docs.aws.amazon.com/Route53/latest/DeveloperGuide/DNSLimitations.html
+const (
+	maxBatchRecords = 1000
+	maxBatchChars   = 32000
+
+	// DefaultRoute53RequestsPerSecond corresponds to
+	// the limit of requests per second that Route53
+	// imposes on each account.
+	DefaultRoute53RequestsPerSecond = 5
+
+	defaultRoute53MaxRetries = 5
+	defaultRoute53Backoff    = 500 * time.Millisecond
+)
+
+// changeWeight computes how much a change counts towards
+// the limits of number of records and characters of a
+// batch.
+func changeWeight(change *route53.Change) (records, chars int) {
+	var recordSet = change.ResourceRecordSet
+
+	if recordSet.AliasTarget != nil {
+		records = 1
+	}
+
+	for _, resourceRecord := range recordSet.ResourceRecords {
+		records++
+		chars += len(*resourceRecord.Value)
+	}
+
+	if *change.Action == route53.ChangeActionUpsert {
+		records *= 2
+		chars *= 2
+	}
+
+	return
+}
+
+// batchChanges splits groups of changes into batches
+// that respect the limits of a single request.
+//
+// Changes in the same group (e.g., a record and its
+// ownership record) are never split across batches so
+// that they're applied atomically.
+func batchChanges(groups [][]*route53.Change) (batches [][]*route53.Change) {
+	var (
+		batch        []*route53.Change
+		batchRecords int
+		batchChars   int
+	)
+
+	for _, group := range groups {
+		var groupRecords, groupChars int
+
+		for _, change := range group {
+			records, chars := changeWeight(change)
+			groupRecords += records
+			groupChars += chars
+		}
+
+		if len(batch) > 0 &&
+			(batchRecords+groupRecords > maxBatchRecords ||
+				batchChars+groupChars > maxBatchChars) {
+			batches = append(batches, batch)
+			batch, batchRecords, batchChars = nil, 0, 0
+		}
+
+		batch = append(batch, group...)
+		batchRecords += groupRecords
+		batchChars += groupChars
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return
+}
+
+// rateLimiter spaces calls so that no more than a
+// given number happen per second.
+type rateLimiter struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Second / time.Duration(perSecond),
+	}
+}
+
+// Wait blocks until a call is allowed.
+func (l *rateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mtx.Unlock()
+
+	time.Sleep(wait)
+}
+
+// callRoute53 performs a Route53 call respecting the
+// rate limit and retrying with exponential backoff
+// (plus jitter) when throttled.
+func (a *Auto) callRoute53(call func() error) (err error) {
+	var backoff = a.route53Backoff
+
+	for attempt := 0; ; attempt++ {
+		a.route53Limiter.Wait()
+
+		err = call()
+		if err == nil || !request.IsErrorThrottle(err) ||
+			attempt >= a.route53MaxRetries {
+			return
+		}
+
+		a.logger.Warn().
+			Err(err).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Msg("route53 request throttled")
+
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff)+1)))
+		backoff *= 2
+	}
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChange(action string, values ...string) *route53.Change {
+	change := &route53.Change{
+		Action:            aws.String(action),
+		ResourceRecordSet: &route53.ResourceRecordSet{},
+	}
+
+	for _, value := range values {
+		change.ResourceRecordSet.ResourceRecords = append(
+			change.ResourceRecordSet.ResourceRecords,
+			&route53.ResourceRecord{Value: aws.String(value)})
+	}
+
+	return change
+}
+
+func TestChangeWeight(t *testing.T) {
+	records, chars := changeWeight(newTestChange("CREATE", "10.0.0.1", "10.0.0.2"))
+	assert.Equal(t, 2, records)
+	assert.Equal(t, 16, chars)
+
+	records, chars = changeWeight(newTestChange("UPSERT", "10.0.0.1", "10.0.0.2"))
+	assert.Equal(t, 4, records)
+	assert.Equal(t, 32, chars)
+}
+
+func TestBatchChanges(t *testing.T) {
+	var testCases = []struct {
+		desc     string
+		groups   [][]*route53.Change
+		expected []int
+	}{
+		{
+			desc:     "nothing",
+			groups:   nil,
+			expected: nil,
+		},
+		{
+			desc: "single batch",
+			groups: [][]*route53.Change{
+				{newTestChange("CREATE", "a"), newTestChange("CREATE", "1.1.1.1")},
+				{newTestChange("DELETE", "a"), newTestChange("DELETE", "1.1.1.2")},
+			},
+			expected: []int{4},
+		},
+		{
+			desc: "split on number of records",
+			groups: func() (groups [][]*route53.Change) {
+				for i := 0; i < 1200; i++ {
+					groups = append(groups, []*route53.Change{
+						newTestChange("CREATE", strconv.Itoa(i)),
+					})
+				}
+				return
+			}(),
+			expected: []int{1000, 200},
+		},
+		{
+			desc: "upserts count twice",
+			groups: func() (groups [][]*route53.Change) {
+				for i := 0; i < 600; i++ {
+					groups = append(groups, []*route53.Change{
+						newTestChange("UPSERT", strconv.Itoa(i)),
+					})
+				}
+				return
+			}(),
+			expected: []int{500, 100},
+		},
+		{
+			desc: "split on number of characters without splitting groups",
+			groups: [][]*route53.Change{
+				{newTestChange("CREATE", strings.Repeat("a", 20000))},
+				{newTestChange("CREATE", "a"), newTestChange("CREATE", strings.Repeat("a", 15000))},
+				{newTestChange("CREATE", "a")},
+			},
+			expected: []int{1, 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var sizes []int
+
+			for _, batch := range batchChanges(tc.groups) {
+				sizes = append(sizes, len(batch))
+			}
+
+			assert.Equal(t, tc.expected, sizes)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	selectorGroupPrefix = "selector:"
+
+	// LifecycleSpot and LifecycleOnDemand are the
+	// lifecycles of instances.
+	LifecycleSpot     = "spot"
+	LifecycleOnDemand = "on-demand"
+)
+
+// InstanceSelector restricts the instances that a rule
+// publishes. Each of the fields that is set must match.
+//
+// Rules without an AutoScalingGroup select among all
+// the instances of the account (including the ones that
+// don't belong to any autoscaling group).
+type InstanceSelector struct {
+
+	// Tags maps tag keys to glob patterns (`*` and `?`)
+	// that their values must match. `*` matches any
+	// value, requiring the tag to exist.
+	Tags map[string]string `yaml:"Tags"`
+
+	// AvailabilityZones, Subnets and VPCs list the
+	// accepted availability zones (e.g., us-east-1a),
+	// subnet IDs and VPC IDs.
+	AvailabilityZones []string `yaml:"AvailabilityZones"`
+	Subnets           []string `yaml:"Subnets"`
+	VPCs              []string `yaml:"VPCs"`
+
+	// Lifecycle is either spot or on-demand.
+	Lifecycle string `yaml:"Lifecycle"`
+}
+
+// Validate verifies that the selector can be used.
+func (s *InstanceSelector) Validate() (err error) {
+	switch s.Lifecycle {
+	case "", LifecycleSpot, LifecycleOnDemand:
+	default:
+		err = errors.Errorf(
+			"selector Lifecycle must be spot or on-demand - %s provided",
+			s.Lifecycle)
+		return
+	}
+
+	return
+}
+
+// Matches indicates whether the instance is selected.
+func (s *InstanceSelector) Matches(instance *Instance) bool {
+	for key, pattern := range s.Tags {
+		value, present := instance.Tags[key]
+		if !present || !globMatch(pattern, value) {
+			return false
+		}
+	}
+
+	if len(s.AvailabilityZones) != 0 &&
+		!containsString(s.AvailabilityZones, instance.AvailabilityZone) {
+		return false
+	}
+
+	if len(s.Subnets) != 0 && !containsString(s.Subnets, instance.SubnetId) {
+		return false
+	}
+
+	if len(s.VPCs) != 0 && !containsString(s.VPCs, instance.VpcId) {
+		return false
+	}
+
+	if s.Lifecycle != "" && s.Lifecycle != instance.Lifecycle {
+		return false
+	}
+
+	return true
+}
+
+// Filters retrieves the EC2 filters that narrow the
+// instances described down to the selected ones (or,
+// where EC2 can't filter, to a superset of them).
+func (s *InstanceSelector) Filters() (filters []*ec2.Filter) {
+	var keys = make([]string, 0, len(s.Tags))
+
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:" + key),
+			Values: aws.StringSlice([]string{s.Tags[key]}),
+		})
+	}
+
+	for _, filter := range []struct {
+		name   string
+		values []string
+	}{
+		{"availability-zone", s.AvailabilityZones},
+		{"subnet-id", s.Subnets},
+		{"vpc-id", s.VPCs},
+	} {
+		if len(filter.values) != 0 {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String(filter.name),
+				Values: aws.StringSlice(filter.values),
+			})
+		}
+	}
+
+	// on-demand instances have no lifecycle in EC2, so
+	// only spot ones can be filtered.
+	if s.Lifecycle == LifecycleSpot {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("instance-lifecycle"),
+			Values: aws.StringSlice([]string{LifecycleSpot}),
+		})
+	}
+
+	return
+}
+
+// String retrieves a canonical representation of the
+// selector, which names the group of instances that
+// rules without an AutoScalingGroup select.
+func (s *InstanceSelector) String() string {
+	var parts []string
+
+	for _, filter := range s.Filters() {
+		parts = append(parts, aws.StringValue(filter.Name)+"="+
+			strings.Join(aws.StringValueSlice(filter.Values), "|"))
+	}
+
+	if s.Lifecycle == LifecycleOnDemand {
+		parts = append(parts, "instance-lifecycle="+LifecycleOnDemand)
+	}
+
+	return selectorGroupPrefix + strings.Join(parts, ",")
+}
+
+// globMatch matches a value against a pattern in which
+// `*` matches any sequence of characters and `?` any
+// single character, like EC2 filters do.
+func globMatch(pattern, value string) bool {
+	return regexp.MustCompile(globExpression(pattern)).MatchString(value)
+}
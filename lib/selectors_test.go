@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceSelectorMatches(t *testing.T) {
+	var instance = &Instance{
+		Id:               "i-1",
+		AvailabilityZone: "us-east-1a",
+		SubnetId:         "subnet-1",
+		VpcId:            "vpc-1",
+		Lifecycle:        LifecycleOnDemand,
+		Tags: map[string]string{
+			"role": "web-frontend",
+			"env":  "prod",
+		},
+	}
+
+	var testCases = []struct {
+		desc     string
+		selector *InstanceSelector
+		expected bool
+	}{
+		{
+			desc:     "empty selector matches everything",
+			selector: &InstanceSelector{},
+			expected: true,
+		},
+		{
+			desc: "tag glob matches",
+			selector: &InstanceSelector{
+				Tags: map[string]string{"role": "web-*", "env": "pro?"},
+			},
+			expected: true,
+		},
+		{
+			desc: "tag glob doesn't match",
+			selector: &InstanceSelector{
+				Tags: map[string]string{"role": "db-*"},
+			},
+			expected: false,
+		},
+		{
+			desc: "wildcard requires the tag to exist",
+			selector: &InstanceSelector{
+				Tags: map[string]string{"team": "*"},
+			},
+			expected: false,
+		},
+		{
+			desc: "placement matches",
+			selector: &InstanceSelector{
+				AvailabilityZones: []string{"us-east-1b", "us-east-1a"},
+				Subnets:           []string{"subnet-1"},
+				VPCs:              []string{"vpc-1"},
+			},
+			expected: true,
+		},
+		{
+			desc: "different vpc",
+			selector: &InstanceSelector{
+				VPCs: []string{"vpc-2"},
+			},
+			expected: false,
+		},
+		{
+			desc:     "different lifecycle",
+			selector: &InstanceSelector{Lifecycle: LifecycleSpot},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.selector.Matches(instance))
+		})
+	}
+}
+
+func TestInstanceSelectorFilters(t *testing.T) {
+	selector := &InstanceSelector{
+		Tags:      map[string]string{"role": "web-*", "env": "prod"},
+		VPCs:      []string{"vpc-1"},
+		Lifecycle: LifecycleSpot,
+	}
+
+	assert.Equal(t, []*ec2.Filter{
+		{Name: aws.String("tag:env"), Values: aws.StringSlice([]string{"prod"})},
+		{Name: aws.String("tag:role"), Values: aws.StringSlice([]string{"web-*"})},
+		{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{"vpc-1"})},
+		{Name: aws.String("instance-lifecycle"), Values: aws.StringSlice([]string{"spot"})},
+	}, selector.Filters())
+
+	assert.Equal(t,
+		"selector:tag:env=prod,tag:role=web-*,vpc-id=vpc-1,instance-lifecycle=spot",
+		selector.String())
+}
+
+func TestGetAutoScalingGroupsWithSelector(t *testing.T) {
+	selector := &InstanceSelector{Tags: map[string]string{"role": "web"}}
+
+	instance := newTestInstance("i-1", "", "10.0.0.1")
+	instance.Tags = append(instance.Tags, &ec2.Tag{
+		Key:   aws.String("role"),
+		Value: aws.String("web"),
+	})
+
+	a := newTestAuto([]*FormattingRule{
+		{Selector: selector, Zone: Zone{Name: "apex1", ID: "zone1"}, Record: "web"},
+	})
+	a.ec2 = &fakeEC2{pages: [][]*ec2.Instance{{instance}}}
+
+	asgs, err := a.GetAutoScalingGroups()
+	require.NoError(t, err)
+
+	require.Contains(t, asgs, selector.String())
+	require.Len(t, asgs[selector.String()].Instances, 1)
+	assert.Equal(t, "web", asgs[selector.String()].Instances[0].Tags["role"])
+}
+
+func TestCreateRecordsWithSelector(t *testing.T) {
+	selector := &InstanceSelector{AvailabilityZones: []string{"us-east-1a"}}
+
+	records, err := CreateRecords(map[string]*AutoScalingGroup{
+		selector.String(): {
+			Name: selector.String(),
+			Instances: []*Instance{
+				{Id: "i-1", PrivateIp: "10.0.0.1", AvailabilityZone: "us-east-1a", Running: true},
+				{Id: "i-2", PrivateIp: "10.0.0.2", AvailabilityZone: "us-east-1b", Running: true},
+			},
+		},
+	}, []*FormattingRule{
+		{
+			Selector: selector,
+			Zone:     Zone{Name: "apex1", ID: "zone1"},
+			Record:   "web",
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"10.0.0.1"}, records[0].Values)
+}
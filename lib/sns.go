@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// maxSNSRequestSize bounds the size of the requests
+// accepted from SNS, whose messages can't exceed 256KB.
+const maxSNSRequestSize = 1 << 20
+
+// SNSHandler is an HTTP endpoint that SNS topics
+// receiving autoscaling notifications can be
+// subscribed to.
+//
+// Subscriptions are confirmed automatically and
+// every notification enqueues a targeted pass for
+// the autoscaling group that it refers to.
+type SNSHandler struct {
+	logger     zerolog.Logger
+	reconciler *Reconciler
+	verifier   *SNSVerifier
+	topics     map[string]bool
+	client     *http.Client
+}
+
+type SNSHandlerConfig struct {
+	Reconciler *Reconciler
+
+	// TopicARNs restricts the topics whose messages
+	// are accepted. If empty, any topic is accepted.
+	TopicARNs []string
+}
+
+func NewSNSHandler(cfg SNSHandlerConfig) (h *SNSHandler, err error) {
+	if cfg.Reconciler == nil {
+		err = errors.Errorf("Reconciler must be specified")
+		return
+	}
+
+	h = &SNSHandler{
+		reconciler: cfg.Reconciler,
+		verifier:   NewSNSVerifier(),
+		topics:     map[string]bool{},
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, topic := range cfg.TopicARNs {
+		h.topics[topic] = true
+	}
+
+	h.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "sns").
+		Logger()
+
+	if len(h.topics) == 0 {
+		h.logger.Warn().
+			Msg("no topics specified - messages from any topic are accepted")
+	}
+
+	return
+}
+
+func (h *SNSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg SNSMessage
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSNSRequestSize)
+
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		h.reject(w, http.StatusBadRequest, errors.Wrapf(err, "malformed message"))
+		return
+	}
+
+	if len(h.topics) != 0 && !h.topics[msg.TopicArn] {
+		h.reject(w, http.StatusForbidden, errors.Errorf(
+			"topic %s is not allowed", msg.TopicArn))
+		return
+	}
+
+	err = h.verifier.Verify(&msg)
+	if err != nil {
+		h.reject(w, http.StatusForbidden, err)
+		return
+	}
+
+	switch msg.Type {
+	case snsSubscriptionConfirmation:
+		err = h.confirmSubscription(&msg)
+	case snsNotification:
+		err = h.handleNotification(&msg)
+	case snsUnsubscribeConfirmation:
+		h.logger.Info().
+			Str("topic", msg.TopicArn).
+			Msg("unsubscribed from topic")
+	default:
+		err = errors.Errorf("unknown message type %s", msg.Type)
+	}
+
+	if err != nil {
+		h.reject(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *SNSHandler) confirmSubscription(msg *SNSMessage) (err error) {
+	err = validateSNSURL(msg.SubscribeURL)
+	if err != nil {
+		return
+	}
+
+	resp, err := h.client.Get(msg.SubscribeURL)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to confirm subscription to topic %s",
+			msg.TopicArn)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf(
+			"unexpected status %d confirming subscription to topic %s",
+			resp.StatusCode, msg.TopicArn)
+		return
+	}
+
+	h.logger.Info().
+		Str("topic", msg.TopicArn).
+		Msg("subscription confirmed")
+	return
+}
+
+func (h *SNSHandler) handleNotification(msg *SNSMessage) (err error) {
+	asg, err := ParseAutoScalingNotification(msg.Message)
+	if err != nil {
+		return
+	}
+
+	if asg == "" {
+		h.logger.Info().
+			Str("topic", msg.TopicArn).
+			Msg("test notification received")
+		return
+	}
+
+	h.logger.Info().
+		Str("topic", msg.TopicArn).
+		Str("autoscaling-group", asg).
+		Msg("autoscaling notification received")
+
+	h.reconciler.Enqueue(asg)
+	return
+}
+
+func (h *SNSHandler) reject(w http.ResponseWriter, code int, err error) {
+	h.logger.Error().
+		Err(err).
+		Msg("rejecting sns message")
+
+	http.Error(w, err.Error(), code)
+}
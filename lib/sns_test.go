@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "arn:aws:sns:us-east-1:123:topic"
+
+func newTestSNSHandler(t *testing.T, topics ...string) (h *SNSHandler, sign func(*SNSMessage)) {
+	r, err := NewReconciler(ReconcilerConfig{
+		Auto:     newTestLoopAuto(&fakeRoute53{}),
+		Interval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	h, err = NewSNSHandler(SNSHandlerConfig{
+		Reconciler: r,
+		TopicARNs:  topics,
+	})
+	require.NoError(t, err)
+
+	h.verifier, sign = newTestSNSVerifier(t)
+	return
+}
+
+func postSNSMessage(h *SNSHandler, msg *SNSMessage) (w *httptest.ResponseRecorder) {
+	body, _ := json.Marshal(msg)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", bytes.NewReader(body)))
+	return
+}
+
+func TestSNSHandlerConfirmsSubscriptions(t *testing.T) {
+	var confirmed string
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed = r.Host + r.URL.String()
+	}))
+	defer server.Close()
+
+	h, sign := newTestSNSHandler(t)
+
+	// every SNS endpoint is served by the test server.
+	h.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial(network, server.Listener.Addr().String())
+			},
+		},
+	}
+
+	msg := &SNSMessage{
+		Type:             snsSubscriptionConfirmation,
+		MessageId:        "id1",
+		Token:            "token1",
+		TopicArn:         testTopic,
+		Message:          "You have chosen to subscribe to the topic",
+		SubscribeURL:     "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=token1",
+		Timestamp:        "2017-12-10T09:19:58.485Z",
+		SignatureVersion: "2",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/cert.pem",
+	}
+	sign(msg)
+
+	w := postSNSMessage(h, msg)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=token1", confirmed)
+
+	// subscriptions are only confirmed with SNS.
+	confirmed = ""
+	msg.SubscribeURL = "https://example.com/?Action=ConfirmSubscription&Token=token1"
+	sign(msg)
+
+	w = postSNSMessage(h, msg)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, confirmed)
+}
+
+func TestSNSHandlerEnqueuesNotifications(t *testing.T) {
+	h, sign := newTestSNSHandler(t)
+
+	msg := &SNSMessage{
+		Type:             snsNotification,
+		MessageId:        "id1",
+		TopicArn:         testTopic,
+		Message:          `{"AutoScalingGroupName": "asg1"}`,
+		Timestamp:        "2017-12-10T09:19:58.485Z",
+		SignatureVersion: "2",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/cert.pem",
+	}
+	sign(msg)
+
+	w := postSNSMessage(h, msg)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"asg1"}, h.reconciler.takePending())
+
+	msg.Message = `{"Event": "autoscaling:TEST_NOTIFICATION"}`
+	sign(msg)
+
+	w = postSNSMessage(h, msg)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, h.reconciler.takePending())
+}
+
+func TestSNSHandlerRejects(t *testing.T) {
+	h, sign := newTestSNSHandler(t, testTopic)
+
+	msg := &SNSMessage{
+		Type:             snsNotification,
+		MessageId:        "id1",
+		TopicArn:         testTopic,
+		Message:          `{"AutoScalingGroupName": "asg1"}`,
+		Timestamp:        "2017-12-10T09:19:58.485Z",
+		SignatureVersion: "2",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/cert.pem",
+	}
+	sign(msg)
+
+	t.Run("bad signature", func(t *testing.T) {
+		tampered := *msg
+		tampered.Message = `{"AutoScalingGroupName": "asg2"}`
+
+		w := postSNSMessage(h, &tampered)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid signature")
+	})
+
+	t.Run("topic not allowed", func(t *testing.T) {
+		other := *msg
+		other.TopicArn = "arn:aws:sns:us-east-1:123:other"
+		sign(&other)
+
+		w := postSNSMessage(h, &other)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", strings.NewReader("{")))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		large := *msg
+		large.Message = strings.Repeat("a", maxSNSRequestSize)
+
+		w := postSNSMessage(h, &large)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sns", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	assert.Empty(t, h.reconciler.takePending())
+}
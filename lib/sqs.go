@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SQSPoller consumes autoscaling notifications from
+// an SQS queue (either sent directly or through an
+// SNS subscription), enqueueing targeted passes for
+// the autoscaling groups that they refer to.
+type SQSPoller struct {
+	logger     zerolog.Logger
+	reconciler *Reconciler
+	sqs        sqsiface.SQSAPI
+	queueURL   string
+}
+
+type SQSPollerConfig struct {
+	Reconciler *Reconciler
+	QueueURL   string
+}
+
+func NewSQSPoller(cfg SQSPollerConfig) (p *SQSPoller, err error) {
+	if cfg.Reconciler == nil {
+		err = errors.Errorf("Reconciler must be specified")
+		return
+	}
+
+	if cfg.QueueURL == "" {
+		err = errors.Errorf("QueueURL must be specified")
+		return
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create aws session")
+		return
+	}
+
+	p = &SQSPoller{
+		reconciler: cfg.Reconciler,
+		sqs:        sqs.New(sess),
+		queueURL:   cfg.QueueURL,
+	}
+
+	p.logger = zerolog.New(os.Stdout).
+		With().
+		Str("from", "sqs").
+		Logger()
+
+	return
+}
+
+// Run long-polls the queue until the context
+// is cancelled.
+func (p *SQSPoller) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		err := p.poll(ctx)
+		if err == nil {
+			continue
+		}
+
+		p.logger.Error().
+			Err(err).
+			Str("queue", p.queueURL).
+			Msg("failed to poll queue")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func (p *SQSPoller) poll(ctx context.Context) (err error) {
+	result, err := p.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(p.queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to receive messages")
+		return
+	}
+
+	for _, message := range result.Messages {
+		asg, err := parseSQSMessage(*message.Body)
+		if err != nil {
+			// malformed messages would otherwise be
+			// received over and over again
+			p.logger.Error().
+				Err(err).
+				Str("message", *message.MessageId).
+				Msg("discarding message")
+		} else if asg != "" {
+			p.logger.Info().
+				Str("autoscaling-group", asg).
+				Msg("autoscaling notification received")
+			p.reconciler.Enqueue(asg)
+		}
+
+		_, err = p.sqs.DeleteMessage(&sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(p.queueURL),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		if err != nil {
+			p.logger.Error().
+				Err(err).
+				Str("message", *message.MessageId).
+				Msg("failed to delete message")
+		}
+	}
+
+	return
+}
+
+// parseSQSMessage retrieves the autoscaling group from
+// a message that might either be an SNS envelope or a
+// raw autoscaling notification.
+func parseSQSMessage(body string) (asg string, err error) {
+	var msg SNSMessage
+
+	err = json.Unmarshal([]byte(body), &msg)
+	if err != nil {
+		err = errors.Wrapf(err, "malformed message %s", body)
+		return
+	}
+
+	if msg.Type == snsNotification && msg.Message != "" {
+		body = msg.Message
+	}
+
+	asg, err = ParseAutoScalingNotification(body)
+	return
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateData is what the templates of a rule (Record,
+// Target and SRV) are rendered with: the fields of the
+// instance, such as its autoscaling group, placement,
+// type, launch time and Index within its group.
+// Templates can use the functions of templateFuncs.
+//
+// For rules whose AutoScalingGroup is a pattern, Match
+// holds the submatches of the pattern against the name
+// of the group (Match 0 being the whole name) and
+// Captures the named ones. For instance, with
+//
+//	/^api-(?P<date>\d+)-(blue|green)$/
+//
+// `{{ .Captures.date }}` and `{{ index .Match 2 }}`
+// render `20260912` and `blue` for `api-20260912-blue`.
+type TemplateData struct {
+	*Instance
+
+	Match    []string
+	Captures map[string]string
+}
+
+// templateData retrieves the data that the templates of
+// the rule are rendered with for `instance`.
+func (f *FormattingRule) templateData(instance *Instance) (data *TemplateData) {
+	data = &TemplateData{
+		Instance: instance,
+		Captures: map[string]string{},
+	}
+
+	if f.groupPattern == nil {
+		return
+	}
+
+	data.Match = f.groupPattern.FindStringSubmatch(instance.AutoScalingGroup)
+
+	for i, name := range f.groupPattern.SubexpNames() {
+		if name != "" && i < len(data.Match) {
+			data.Captures[name] = data.Match[i]
+		}
+	}
+
+	return
+}
+
+// templateFuncs are the functions available to the
+// templates of rules, in addition to the builtin ones:
+//
+//	lower      lowercases a value
+//	replace    replaces every occurrence of a string:
+//	           {{ .Id | replace "i-" "" }}
+//	trunc      keeps the first n characters of a value
+//	ipDashes   replaces the dots (and colons) of an
+//	           address by dashes (10-0-0-1)
+//	tag        retrieves a tag of the instance, empty if
+//	           it doesn't have it
+//	default    replaces empty values: {{ tag "Name" | default "x" }}
+//	sha        hex-encoded SHA-1 of a value
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"replace": func(old, new, value string) string {
+		return strings.Replace(value, old, new, -1)
+	},
+	"trunc": func(n int, value string) string {
+		if n < 0 || n >= len(value) {
+			return value
+		}
+
+		return value[:n]
+	},
+	"ipDashes": func(address string) string {
+		return strings.NewReplacer(".", "-", ":", "-").Replace(address)
+	},
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+
+		return value
+	},
+	"sha": func(value string) string {
+		sum := sha1.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	},
+
+	// tag is bound to the instance on each execution.
+	"tag": func(name string) string {
+		return ""
+	},
+}
+
+// execute renders a template of the rule for
+// `instance`.
+func (f *FormattingRule) execute(tmpl *template.Template, w io.Writer, instance *Instance) (err error) {
+	tmpl, err = tmpl.Clone()
+	if err != nil {
+		return
+	}
+
+	tmpl.Funcs(template.FuncMap{
+		"tag": func(name string) string {
+			return instance.Tags[name]
+		},
+	})
+
+	err = tmpl.Execute(w, f.templateData(instance))
+	return
+}
+
+const (
+	maxDNSNameLength  = 253
+	maxDNSLabelLength = 63
+)
+
+// validateRecordName verifies that a record `name` of
+// the zone `zone` forms a valid DNS name.
+//
+// Empty names (i.e., the apex of the zone) are rejected
+// as they'd produce malformed ownership records.
+func validateRecordName(name, zone string) (err error) {
+	if name == "" {
+		err = errors.Errorf("name is empty")
+		return
+	}
+
+	err = validateDNSName(name + "." + zone)
+	return
+}
+
+// decodeDNSName decodes the escapes that Route53 lists
+// names with (e.g., `\052` for a wildcard), so that they
+// can be compared with rendered names.
+func decodeDNSName(name string) string {
+	var buf = make([]byte, 0, len(name))
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' || i+1 == len(name) {
+			buf = append(buf, name[i])
+			continue
+		}
+
+		if i+3 < len(name) {
+			code, err := strconv.ParseUint(name[i+1:i+4], 8, 8)
+			if err == nil {
+				buf = append(buf, byte(code))
+				i += 3
+				continue
+			}
+		}
+
+		buf = append(buf, name[i+1])
+		i++
+	}
+
+	return string(buf)
+}
+
+// validateDNSName verifies that a name is a sequence of
+// labels of up to 63 letters, digits, hyphens (except
+// at their ends) or underscores, with a wildcard (`*`)
+// allowed as the first label, in up to 253 characters.
+func validateDNSName(name string) (err error) {
+	name = strings.TrimSuffix(name, ".")
+
+	if len(name) > maxDNSNameLength {
+		err = errors.Errorf(
+			"name %s is longer than %d characters",
+			name, maxDNSNameLength)
+		return
+	}
+
+	for i, label := range strings.Split(name, ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+
+		if label == "" || len(label) > maxDNSLabelLength {
+			err = errors.Errorf(
+				"name %s must have labels of 1 to %d characters",
+				name, maxDNSLabelLength)
+			return
+		}
+
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			err = errors.Errorf(
+				"label %s of name %s can't start or end with a hyphen",
+				label, name)
+			return
+		}
+
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+				'0' <= c && c <= '9' || c == '-' || c == '_') {
+				err = errors.Errorf(
+					"label %s of name %s has invalid character %q",
+					label, name, c)
+				return
+			}
+		}
+	}
+
+	return
+}
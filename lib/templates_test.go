@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRecord(t *testing.T) {
+	var instance = &Instance{
+		Id:               "i-0ABC123",
+		PrivateIp:        "10.0.1.2",
+		Ipv6Ip:           "2600:1f18::1",
+		AutoScalingGroup: "api-blue",
+		AvailabilityZone: "us-east-1a",
+		InstanceType:     "t2.micro",
+		Index:            3,
+		Tags:             map[string]string{"Name": "Web Server"},
+	}
+
+	var testCases = []struct {
+		desc        string
+		record      string
+		expected    string
+		shouldError bool
+	}{
+		{
+			desc:     "instance fields",
+			record:   "{{ .AutoScalingGroup }}-{{ .Index }}.{{ .AvailabilityZone }}",
+			expected: "api-blue-3.us-east-1a",
+		},
+		{
+			desc:     "lower and replace",
+			record:   `{{ .Id | replace "i-" "" | lower }}`,
+			expected: "0abc123",
+		},
+		{
+			desc:     "ip dashes",
+			record:   "ip-{{ ipDashes .PrivateIp }}.{{ ipDashes .Ipv6Ip }}",
+			expected: "ip-10-0-1-2.2600-1f18--1",
+		},
+		{
+			desc:     "tag with default",
+			record:   `{{ tag "Role" | default "worker" }}`,
+			expected: "worker",
+		},
+		{
+			desc:     "sha and trunc",
+			record:   `{{ tag "Name" | sha | trunc 8 }}`,
+			expected: "a0303287",
+		},
+		{
+			desc:     "uppercase",
+			record:   `{{ tag "Name" | replace " " "-" }}`,
+			expected: "web-server",
+		},
+		{
+			desc:        "invalid characters",
+			record:      `{{ tag "Name" }}`,
+			shouldError: true,
+		},
+		{
+			desc:        "empty label",
+			record:      `{{ tag "Role" }}.api`,
+			shouldError: true,
+		},
+		{
+			desc:        "empty name",
+			record:      `{{ tag "Role" }}`,
+			shouldError: true,
+		},
+		{
+			desc:        "label ending in hyphen",
+			record:      `{{ .Id | trunc 2 }}`,
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rule := &FormattingRule{
+				Zone:   Zone{ID: "zone123", Name: "ciro-test"},
+				Record: tc.record,
+			}
+			require.NoError(t, rule.ParseRecordTemplate())
+
+			res, err := rule.TemplateRecord(instance)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+}
+
+func TestValidateDNSName(t *testing.T) {
+	var long = "a"
+	for len(long) < 64 {
+		long += "a"
+	}
+
+	assert.NoError(t, validateDNSName("_http._tcp.api.ciro-test."))
+	assert.NoError(t, validateDNSName("*.api.ciro-test"))
+	assert.Error(t, validateDNSName("api.*.ciro-test"))
+	assert.Error(t, validateDNSName(long+".ciro-test"))
+	assert.Error(t, validateDNSName("api..ciro-test"))
+	assert.Error(t, validateDNSName("-api.ciro-test"))
+}
+
+func TestDecodeDNSName(t *testing.T) {
+	assert.Equal(t, "*.api.ciro-test.", decodeDNSName(`\052.api.ciro-test.`))
+	assert.Equal(t, "_auto53-a.*.api", decodeDNSName(`_auto53-a.\052.api`))
+	assert.Equal(t, "api*", decodeDNSName(`api\052`))
+	assert.Equal(t, "a.b", decodeDNSName(`a\.b`))
+	assert.Equal(t, `api\`, decodeDNSName(`api\`))
+}
+
+func TestIndexInstances(t *testing.T) {
+	var (
+		now       = time.Now()
+		instances = []*Instance{
+			{Id: "i-3", LaunchTime: now.Add(time.Minute)},
+			{Id: "i-2", LaunchTime: now},
+			{Id: "i-1", LaunchTime: now},
+		}
+	)
+
+	indexInstances(instances)
+
+	for i, id := range []string{"i-1", "i-2", "i-3"} {
+		assert.Equal(t, id, instances[i].Id)
+		assert.Equal(t, i, instances[i].Index)
+	}
+}
@@ -3,10 +3,17 @@ package lib
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"text/template"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/mitchellh/hashstructure"
 	"github.com/pkg/errors"
 )
@@ -20,21 +27,98 @@ const (
 	EvaluationRemoveRecord
 )
 
+func (t EvaluationType) String() string {
+	switch t {
+	case EvaluationAddRecord:
+		return "create"
+	case EvaluationUpdateRecord:
+		return "update"
+	case EvaluationRemoveRecord:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText makes evaluation types show up with
+// their names when encoded (e.g., in API responses).
+func (t EvaluationType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
 // Zone corresponds to an AWS zone
 // with might be either private or not
 // and be ambiguous about name.
+//
+// Zones without an ID are looked up by Name, with
+// Private and VPC picking between hosted zones of the
+// same name.
 type Zone struct {
 	Name string `yaml:"Name"`
 	ID   string `yaml:"ID"`
+
+	// Private restricts the lookup to private (true) or
+	// public (false) hosted zones.
+	Private *bool `yaml:"Private" json:",omitempty" hash:"ignore"`
+
+	// VPC restricts the lookup to the private hosted
+	// zones associated with the VPC of that ID.
+	VPC string `yaml:"VPC" json:",omitempty" hash:"ignore"`
 }
 
-// Record corresponds to an A record that maps
-// a DNS record to multiple IPs
+// Record corresponds to a record set that maps
+// a DNS record to multiple values (IPs or, for
+// CNAMEs, a single DNS name) or to an alias target.
 type Record struct {
-	Zone Zone
-	Name string
-	IPs  []string `hash:"set"`
-	hash uint64   `hash:"ignore"`
+	Zone   Zone
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string `hash:"set"`
+
+	// Alias is the target of alias record sets, which
+	// have neither values nor TTL.
+	Alias *AliasTarget `json:",omitempty"`
+
+	// Owned indicates whether the record has been
+	// created by this auto53 owner, as marked by its
+	// companion ownership TXT record.
+	// Only owned records are ever removed.
+	Owned bool `hash:"ignore"`
+
+	// SetIdentifier distinguishes record sets that
+	// share the same name and type (e.g., one multivalue
+	// answer record set per instance).
+	SetIdentifier string `json:",omitempty"`
+
+	// Routing policy attributes of the record set (as
+	// in route53.ResourceRecordSet), only set for the
+	// corresponding policies.
+	Weight           *int64 `json:",omitempty"`
+	Region           string `json:",omitempty"`
+	Failover         string `json:",omitempty"`
+	MultiValueAnswer bool   `json:",omitempty"`
+
+	// HealthCheckId is the Route53 health check that
+	// the record set is tied to.
+	HealthCheckId string `json:",omitempty"`
+
+	// HealthCheck is the configuration of the health
+	// check that the record set must be tied to.
+	// Only set in desired records.
+	HealthCheck *HealthCheckConfig `hash:"ignore" json:"-"`
+
+	hash uint64 `hash:"ignore"`
+}
+
+// Key identifies a record within all the zones
+// regardless of its values.
+func (r *Record) Key() string {
+	if r.SetIdentifier != "" {
+		return r.Zone.ID + "/" + r.Name + "/" + r.Type + "/" + r.SetIdentifier
+	}
+
+	return r.Zone.ID + "/" + r.Name + "/" + r.Type
 }
 
 func (r *Record) ComputeHash() (err error) {
@@ -57,12 +141,16 @@ func (r *Record) ComputeHash() (err error) {
 // mutates Route53.
 type Evaluation struct {
 
-	// Record is the record that we either add or remove
-	// into/of a zone.
+	// Record is the record that we either add, update
+	// or remove into/of a zone.
 	Record *Record
 
+	// Previous is the record currently in the zone
+	// that gets replaced by Record in an update.
+	Previous *Record
+
 	// Type is the type of evaluation to perform:
-	// add or remove.
+	// add, update or remove.
 	Type EvaluationType
 }
 
@@ -86,7 +174,70 @@ type Instance struct {
 	Id        string
 	PublicIp  string
 	PrivateIp string
-	Tags      map[string]string
+
+	// ElasticIp is the public address of the instance
+	// when it corresponds to an Elastic IP.
+	ElasticIp string
+
+	// SecondaryIp is the primary private address of
+	// the secondary network interface (device index 1)
+	// if one is attached.
+	SecondaryIp string
+
+	// AutoScalingGroup is the name of the autoscaling
+	// group of the instance, if any.
+	AutoScalingGroup string
+
+	// InstanceType is the type of the instance (e.g.,
+	// t2.micro) and LaunchTime the time at which it was
+	// launched.
+	InstanceType string
+	LaunchTime   time.Time
+
+	// Index is the position of the instance in its
+	// group, ordered by launch time (and ID), starting
+	// at 0.
+	Index int
+
+	// Ordinal is the slot allocated to the instance in
+	// its autoscaling group (starting at 1) if a rule
+	// of the group has Ordinals, 0 otherwise.
+	Ordinal int
+
+	// Placement of the instance.
+	AvailabilityZone string
+	SubnetId         string
+	VpcId            string
+
+	// Lifecycle is either spot or on-demand.
+	Lifecycle string
+
+	// PrivateDnsName and PublicDnsName are the DNS
+	// names that EC2 assigns to the instance, if any.
+	PrivateDnsName string
+	PublicDnsName  string
+
+	// Ipv6Ip is the first IPv6 address of the primary
+	// network interface, if any.
+	Ipv6Ip string
+
+	// SecondaryIpv6Ip is the first IPv6 address of the
+	// secondary network interface, if any.
+	SecondaryIpv6Ip string
+
+	// LifecycleState is the state of the instance in
+	// its autoscaling group (e.g., InService).
+	// Only retrieved when a rule's membership requires
+	// it.
+	LifecycleState string
+
+	// Healthy indicates whether the instance passes
+	// both EC2 status checks.
+	// Only retrieved when a rule's membership requires
+	// it.
+	Healthy bool
+
+	Tags map[string]string
 
 	// Running indicates whether the machine is
 	// in "running" state of not.
@@ -107,8 +258,17 @@ type FormattingRule struct {
 	// in AWS.
 	// This is used to match an instance's autoscaling
 	// group with records to be created.
+	// It can also be a pattern matching several
+	// groups: a glob (e.g., api-*) or a regular
+	// expression enclosed in slashes, whose submatches
+	// the templates can use (see TemplateData).
 	AutoScalingGroup string `yaml:"AutoScalingGroup"`
 
+	// Selector restricts the instances that the rule
+	// publishes. Rules without an AutoScalingGroup
+	// must have one.
+	Selector *InstanceSelector `yaml:"Selector"`
+
 	// Zone is the private or public zone created
 	// in Route53 to use as the domain for the
 	// record.
@@ -117,7 +277,72 @@ type FormattingRule struct {
 	// Public indicates whether a public IP should be
 	// retrieved instead of a private one.
 	// By default private IPs are picked.
-	Public bool `yaml:"Private"`
+	// Shorthand for `Address: public`.
+	Public bool `yaml:"Public"`
+
+	// Address is the address of the instances that
+	// records point to: private (default), public,
+	// elastic or secondary.
+	// AAAA records point to the IPv6 address of the
+	// primary network interface (or of the secondary
+	// one, for secondary).
+	Address AddressType `yaml:"Address"`
+
+	// Type is the type of the records: A (default),
+	// AAAA, both, CNAME or SRV.
+	Type RecordType `yaml:"Type"`
+
+	// Target is a template (like Record) of the DNS
+	// name that CNAME records and alias records point
+	// to. For instance:
+	//	{{ .PrivateDnsName }}
+	Target string `yaml:"Target"`
+
+	// Reverse makes the rule also maintain PTR records
+	// that point the addresses of its A and AAAA records
+	// back at their names, in reverse lookup zones.
+	Reverse *ReverseConfig `yaml:"Reverse"`
+
+	// SRV configures the records of SRV rules, whose
+	// Record is the name of the service (e.g.,
+	// _http._tcp.api) and Target the name of each
+	// instance.
+	SRV *SRVConfig `yaml:"SRV"`
+
+	// Alias makes the records (of type A, AAAA or
+	// both) Route53 aliases to Target (e.g., a load
+	// balancer or another record) instead of pointing
+	// to the addresses of the instances.
+	Alias *AliasConfig `yaml:"Alias"`
+
+	// Ordinals allocates a stable slot to each running
+	// instance of the autoscaling group, the lowest
+	// free one, so that templates can use `.Ordinal`
+	// (e.g., worker-{{ .Ordinal }}). Slots are persisted
+	// as instance tags and released once instances stop
+	// running.
+	Ordinals bool `yaml:"Ordinals"`
+
+	// Membership is the policy that decides which
+	// instances the records point to: running (default),
+	// in-service or healthy.
+	Membership MembershipPolicy `yaml:"Membership"`
+
+	// HealthCheck makes each instance be published
+	// as a multivalue answer record set tied to a
+	// Route53 health check of its address, so that
+	// Route53 stops answering with unhealthy instances
+	// between passes.
+	HealthCheck *HealthCheckConfig `yaml:"HealthCheck"`
+
+	// TTL is the time to live of the records in
+	// seconds. Defaults to DefaultTTL.
+	TTL int64 `yaml:"TTL"`
+
+	// Routing is the routing policy of the records.
+	// Defaults to simple routing (or multivalue for
+	// rules with a HealthCheck).
+	Routing *RoutingPolicy `yaml:"Routing"`
 
 	// Record is a template that is used
 	// as the name for the entry in the zone.
@@ -133,12 +358,882 @@ type FormattingRule struct {
 
 	// template corresponds to the parsed Record template
 	template *template.Template `yaml:"-"`
+
+	// targetTemplate corresponds to the parsed Target
+	// template.
+	targetTemplate *template.Template `yaml:"-"`
+
+	// srvTemplates correspond to the parsed Priority,
+	// Weight and Port templates of SRV.
+	srvTemplates []*template.Template `yaml:"-"`
+
+	// groupPattern corresponds to the compiled
+	// AutoScalingGroup pattern, if it is one.
+	groupPattern *regexp.Regexp `yaml:"-"`
+}
+
+// AddressType indicates which of the addresses of an
+// instance is used as the value of records.
+type AddressType string
+
+const (
+	// AddressPrivate is the primary private IPv4 address.
+	AddressPrivate AddressType = "private"
+
+	// AddressPublic is the public IPv4 address, either
+	// assigned by AWS or an Elastic IP.
+	AddressPublic AddressType = "public"
+
+	// AddressElastic is the public IPv4 address only if
+	// it's an Elastic IP.
+	AddressElastic AddressType = "elastic"
+
+	// AddressSecondary is the primary private IPv4
+	// address of the secondary network interface.
+	AddressSecondary AddressType = "secondary"
+)
+
+// AddressType resolves the type of address that the
+// rule picks from instances.
+func (f *FormattingRule) AddressType() (addressType AddressType, err error) {
+	addressType = f.Address
+
+	if f.Public {
+		if addressType != "" && addressType != AddressPublic {
+			err = errors.Errorf(
+				"rule for record '%s' can't be Public with Address %s",
+				f.Record, addressType)
+			return
+		}
+
+		addressType = AddressPublic
+	}
+
+	switch addressType {
+	case "":
+		addressType = AddressPrivate
+	case AddressPrivate, AddressPublic, AddressElastic, AddressSecondary:
+	default:
+		err = errors.Errorf(
+			"rule for record '%s' has unknown Address %s",
+			f.Record, addressType)
+		return
+	}
+
+	return
+}
+
+// InstanceAddress retrieves the address of an instance
+// that the rule picks for records of type `recordType`
+// (A or AAAA), failing if the instance doesn't have it.
+func (f *FormattingRule) InstanceAddress(instance *Instance, recordType string) (address string, err error) {
+	addressType, err := f.AddressType()
+	if err != nil {
+		return
+	}
+
+	if recordType == "AAAA" {
+		switch addressType {
+		case AddressPrivate, AddressPublic:
+			address = instance.Ipv6Ip
+		case AddressSecondary:
+			address = instance.SecondaryIpv6Ip
+		case AddressElastic:
+			err = errors.Errorf(
+				"rule for record '%s' can't pick elastic addresses for AAAA records",
+				f.Record)
+			return
+		}
+
+		if address == "" {
+			err = &missingAddressError{
+				Instance: instance.Id,
+				Address:  addressType,
+				IPv6:     true,
+			}
+			return
+		}
+
+		return
+	}
+
+	switch addressType {
+	case AddressPrivate:
+		address = instance.PrivateIp
+	case AddressPublic:
+		address = instance.PublicIp
+	case AddressElastic:
+		address = instance.ElasticIp
+	case AddressSecondary:
+		address = instance.SecondaryIp
+	}
+
+	if address == "" {
+		err = &missingAddressError{
+			Instance: instance.Id,
+			Address:  addressType,
+		}
+		return
+	}
+
+	return
+}
+
+// missingAddressError indicates that an instance lacks
+// the address that a rule picks (e.g., an instance
+// without a public IP).
+type missingAddressError struct {
+	Instance string
+	Address  AddressType
+	IPv6     bool
+}
+
+func (e *missingAddressError) Error() string {
+	if e.IPv6 {
+		return fmt.Sprintf("instance %s doesn't have a %s IPv6 address",
+			e.Instance, e.Address)
+	}
+
+	return fmt.Sprintf("instance %s doesn't have a %s address",
+		e.Instance, e.Address)
+}
+
+// RecordType indicates the types of records that a
+// rule produces.
+type RecordType string
+
+const (
+	RecordTypeA     RecordType = "A"
+	RecordTypeAAAA  RecordType = "AAAA"
+	RecordTypeCNAME RecordType = "CNAME"
+	RecordTypeSRV   RecordType = "SRV"
+
+	// RecordTypeBoth produces both A and AAAA records,
+	// reconciled independently.
+	RecordTypeBoth RecordType = "both"
+)
+
+// RecordTypes resolves the types of the records that
+// the rule produces.
+func (f *FormattingRule) RecordTypes() (types []string, err error) {
+	switch f.Type {
+	case "", RecordTypeA:
+		types = []string{"A"}
+	case RecordTypeAAAA:
+		types = []string{"AAAA"}
+	case RecordTypeBoth:
+		types = []string{"A", "AAAA"}
+	case RecordTypeCNAME:
+		types = []string{"CNAME"}
+	case RecordTypeSRV:
+		types = []string{"SRV"}
+	default:
+		err = errors.Errorf(
+			"rule for record '%s' has unknown Type %s",
+			f.Record, f.Type)
+		return
+	}
+
+	var named = f.Type == RecordTypeCNAME || f.Type == RecordTypeSRV
+
+	switch {
+	case named && f.Alias != nil:
+		err = errors.Errorf(
+			"rule for record '%s' can't be a %s and an Alias",
+			f.Record, f.Type)
+		return
+	case (named || f.Alias != nil) && f.Target == "":
+		err = errors.Errorf(
+			"rule for record '%s' requires a Target",
+			f.Record)
+		return
+	case !named && f.Alias == nil && f.Target != "":
+		err = errors.Errorf(
+			"rule for record '%s' can only have a Target for CNAME, SRV or Alias records",
+			f.Record)
+		return
+	case (f.Type == RecordTypeSRV) != (f.SRV != nil):
+		err = errors.Errorf(
+			"rule for record '%s' must have an SRV configuration if and only if its Type is SRV",
+			f.Record)
+		return
+	case (named || f.Alias != nil) && f.HealthCheck != nil:
+		err = errors.Errorf(
+			"rule for record '%s' can only have a HealthCheck for address records",
+			f.Record)
+		return
+	}
+
+	return
+}
+
+// AliasConfig configures the alias records of a rule.
+type AliasConfig struct {
+
+	// HostedZoneId is the hosted zone of the target
+	// (e.g., the canonical hosted zone of a load
+	// balancer). Defaults to the zone of the rule,
+	// for aliases to other records.
+	HostedZoneId string `yaml:"HostedZoneId"`
+
+	// EvaluateTargetHealth makes Route53 take the
+	// health of the target into account.
+	EvaluateTargetHealth bool `yaml:"EvaluateTargetHealth"`
+}
+
+// ReverseConfig configures the reverse lookup zones
+// that PTR records are maintained in.
+//
+// Addresses of a family without a zone (or outside of
+// it) aren't published.
+type ReverseConfig struct {
+
+	// IPv4 is the in-addr.arpa zone of IPv4 addresses
+	// (e.g., 0.10.in-addr.arpa).
+	IPv4 *Zone `yaml:"IPv4"`
+
+	// IPv6 is the ip6.arpa zone of IPv6 addresses.
+	IPv6 *Zone `yaml:"IPv6"`
+}
+
+// Validate verifies that the reverse zones are named
+// after the addresses of their family, as otherwise no
+// PTR record would ever fall in them.
+func (r *ReverseConfig) Validate() (err error) {
+	for _, reverse := range []struct {
+		zone   *Zone
+		suffix string
+	}{
+		{r.IPv4, "in-addr.arpa"},
+		{r.IPv6, "ip6.arpa"},
+	} {
+		if reverse.zone == nil {
+			continue
+		}
+
+		name := normalizeZoneName(reverse.zone.Name)
+		if name != reverse.suffix && !strings.HasSuffix(name, "."+reverse.suffix) {
+			err = errors.Errorf(
+				"reverse zone '%s' must be an %s zone",
+				reverse.zone.Name, reverse.suffix)
+			return
+		}
+	}
+
+	return
+}
+
+// Zones retrieves the zones that the records of the
+// rule live in, including reverse lookup zones.
+func (f *FormattingRule) Zones() (zones []Zone) {
+	for _, zone := range f.zoneRefs() {
+		zones = append(zones, *zone)
+	}
+
+	return
+}
+
+// zoneRefs retrieves references to the zones of the
+// rule so that they can be resolved in place.
+func (f *FormattingRule) zoneRefs() (zones []*Zone) {
+	zones = []*Zone{&f.Zone}
+
+	if f.Reverse == nil {
+		return
+	}
+
+	if f.Reverse.IPv4 != nil {
+		zones = append(zones, f.Reverse.IPv4)
+	}
+
+	if f.Reverse.IPv6 != nil {
+		zones = append(zones, f.Reverse.IPv6)
+	}
+
+	return
+}
+
+// normalizeZones lowercases the names of the zones of
+// the rule and trims their trailing dot, matching the
+// names that records are listed with.
+func (f *FormattingRule) normalizeZones() {
+	for _, zone := range f.zoneRefs() {
+		zone.Name = normalizeZoneName(zone.Name)
+	}
+}
+
+// ReverseRecord creates the PTR record that points
+// `address` back at the record `name` of the rule's
+// zone, if the rule has a reverse zone for it.
+func (f *FormattingRule) ReverseRecord(name, address string) (record *Record, err error) {
+	if f.Reverse == nil {
+		return
+	}
+
+	var (
+		ip   = net.ParseIP(address)
+		zone *Zone
+		ptr  string
+	)
+
+	if ip == nil {
+		err = errors.Errorf("malformed address %s", address)
+		return
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		zone = f.Reverse.IPv4
+		ptr = fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa",
+			ip4[3], ip4[2], ip4[1], ip4[0])
+	} else {
+		var nibbles = make([]string, 0, 2*net.IPv6len)
+
+		zone = f.Reverse.IPv6
+		for i := net.IPv6len - 1; i >= 0; i-- {
+			nibbles = append(nibbles,
+				strconv.FormatUint(uint64(ip[i]&0x0f), 16),
+				strconv.FormatUint(uint64(ip[i]>>4), 16))
+		}
+		ptr = strings.Join(nibbles, ".") + ".ip6.arpa"
+	}
+
+	if zone == nil || !strings.HasSuffix(ptr, "."+zone.Name) {
+		return
+	}
+
+	ttl := f.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	record = &Record{
+		Zone:   *zone,
+		Name:   strings.TrimSuffix(ptr, "."+zone.Name),
+		Type:   "PTR",
+		TTL:    ttl,
+		Values: []string{name + "." + f.Zone.Name + "."},
+	}
+
+	return
+}
+
+// SRVConfig configures the SRV records of a rule.
+//
+// Each field is a template (like Record) rendered for
+// each instance, so that they can come from tags:
+//
+//	Port: '{{ index .Tags "service-port" }}'
+type SRVConfig struct {
+
+	// Priority of the instances (0 to 65535, lower is
+	// preferred). Defaults to 0.
+	Priority string `yaml:"Priority"`
+
+	// Weight of the instances among the ones with the
+	// same priority (0 to 65535). Defaults to 0.
+	Weight string `yaml:"Weight"`
+
+	// Port that the service listens on (0 to 65535).
+	Port string `yaml:"Port"`
+}
+
+// InstanceValue retrieves the value that the rule
+// produces for `instance` in records of type
+// `recordType`: an address, the DNS name of a CNAME,
+// the priority, weight, port and target of an SRV or,
+// for aliases, nothing.
+func (f *FormattingRule) InstanceValue(instance *Instance, recordType string) (value string, err error) {
+	switch recordType {
+	case "CNAME":
+		value, err = f.TemplateTarget(instance)
+		return
+	case "SRV":
+		value, err = f.templateSRV(instance)
+		return
+	}
+
+	if f.Alias != nil {
+		return
+	}
+
+	value, err = f.InstanceAddress(instance, recordType)
+	return
+}
+
+// templateSRV renders the value of the SRV record of
+// `instance`.
+func (f *FormattingRule) templateSRV(instance *Instance) (value string, err error) {
+	var fields = make([]uint16, len(f.srvTemplates))
+
+	for i, tmpl := range f.srvTemplates {
+		var (
+			buf    = new(bytes.Buffer)
+			number uint64
+		)
+
+		err = f.execute(tmpl, buf, instance)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to template SRV %s with instance data %+v",
+				tmpl.Name(), instance)
+			return
+		}
+
+		if buf.Len() == 0 && tmpl.Name() != "port" {
+			continue
+		}
+
+		number, err = strconv.ParseUint(strings.TrimSpace(buf.String()), 10, 16)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"SRV %s of instance %s must be a number between 0 and 65535",
+				tmpl.Name(), instance.Id)
+			return
+		}
+
+		fields[i] = uint16(number)
+	}
+
+	target, err := f.TemplateTarget(instance)
+	if err != nil {
+		return
+	}
+
+	value = fmt.Sprintf("%d %d %d %s", fields[0], fields[1], fields[2], target)
+	return
+}
+
+// InstanceAlias retrieves the alias target that the
+// rule produces for `instance`, if any.
+func (f *FormattingRule) InstanceAlias(instance *Instance) (alias *AliasTarget, err error) {
+	if f.Alias == nil {
+		return
+	}
+
+	target, err := f.TemplateTarget(instance)
+	if err != nil {
+		return
+	}
+
+	alias = &AliasTarget{
+		DNSName:              target,
+		HostedZoneId:         f.Alias.HostedZoneId,
+		EvaluateTargetHealth: f.Alias.EvaluateTargetHealth,
+	}
+
+	if alias.HostedZoneId == "" {
+		alias.HostedZoneId = f.Zone.ID
+	}
+
+	return
+}
+
+// HealthCheckConfig configures the Route53 health
+// checks created for the instances of a rule.
+type HealthCheckConfig struct {
+
+	// Type is the protocol used to check the instances:
+	// HTTP, HTTPS or TCP.
+	Type string `yaml:"Type"`
+
+	// Port is the port checked on the instances.
+	Port int64 `yaml:"Port"`
+
+	// Path is the path requested by HTTP and HTTPS
+	// checks (e.g., /healthz).
+	Path string `yaml:"Path"`
+
+	// RequestInterval is the number of seconds between
+	// checks: 10 or 30 (default).
+	RequestInterval int64 `yaml:"RequestInterval"`
+
+	// FailureThreshold is the number of consecutive
+	// checks that must fail (or succeed) for the
+	// instance to be considered unhealthy (or healthy):
+	// 1 to 10, 3 by default.
+	FailureThreshold int64 `yaml:"FailureThreshold"`
+}
+
+// Validate verifies that the health check can be
+// created by Route53.
+func (c *HealthCheckConfig) Validate() (err error) {
+	switch c.Type {
+	case route53.HealthCheckTypeHttp, route53.HealthCheckTypeHttps:
+	case route53.HealthCheckTypeTcp:
+		if c.Path != "" {
+			err = errors.Errorf("TCP health checks can't have a Path")
+			return
+		}
+	default:
+		err = errors.Errorf(
+			"health check Type must be HTTP, HTTPS or TCP - %s provided",
+			c.Type)
+		return
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		err = errors.Errorf(
+			"health check Port must be between 1 and 65535 - %d provided",
+			c.Port)
+		return
+	}
+
+	if c.RequestInterval != 0 && c.RequestInterval != 10 && c.RequestInterval != 30 {
+		err = errors.Errorf(
+			"health check RequestInterval must be 10 or 30 - %d provided",
+			c.RequestInterval)
+		return
+	}
+
+	if c.FailureThreshold < 0 || c.FailureThreshold > 10 {
+		err = errors.Errorf(
+			"health check FailureThreshold must be between 1 and 10 - %d provided",
+			c.FailureThreshold)
+		return
+	}
+
+	return
+}
+
+// AliasTarget is the target of an alias record set
+// (as in route53.AliasTarget).
+type AliasTarget struct {
+	DNSName              string
+	HostedZoneId         string
+	EvaluateTargetHealth bool
+}
+
+// DefaultTTL is the time to live of records whose
+// rules don't specify one.
+const DefaultTTL = 300
+
+// RoutingPolicyType indicates how Route53 answers
+// queries for record sets sharing a name.
+type RoutingPolicyType string
+
+const (
+	// RoutingSimple publishes a single record set with
+	// the addresses of all the instances.
+	RoutingSimple RoutingPolicyType = "simple"
+
+	// RoutingWeighted publishes the instances of the
+	// rule as a record set answered in proportion to
+	// its Weight.
+	RoutingWeighted RoutingPolicyType = "weighted"
+
+	// RoutingLatency publishes the instances of the
+	// rule as a record set answered to the clients
+	// closest to its Region.
+	RoutingLatency RoutingPolicyType = "latency"
+
+	// RoutingMultiValue publishes each instance as a
+	// record set of its own.
+	RoutingMultiValue RoutingPolicyType = "multivalue"
+
+	// RoutingFailover publishes the instances of the
+	// rule as the PRIMARY or SECONDARY record set.
+	RoutingFailover RoutingPolicyType = "failover"
+)
+
+// RoutingPolicy configures the routing policy of the
+// records of a rule.
+type RoutingPolicy struct {
+	Type RoutingPolicyType `yaml:"Type"`
+
+	// SetIdentifier distinguishes the record set of the
+	// rule from the ones of other rules with the same
+	// name. Required by weighted, latency and failover
+	// policies (multivalue ones use the instance IDs).
+	SetIdentifier string `yaml:"SetIdentifier"`
+
+	// Weight is the weight (0 to 255) of weighted
+	// record sets.
+	Weight int64 `yaml:"Weight"`
+
+	// Region is the AWS region of latency record sets.
+	Region string `yaml:"Region"`
+
+	// Failover is either PRIMARY or SECONDARY for
+	// failover record sets.
+	Failover string `yaml:"Failover"`
+}
+
+// RoutingPolicy resolves and validates the routing
+// policy of the rule.
+func (f *FormattingRule) RoutingPolicy() (policy RoutingPolicy, err error) {
+	if f.Routing != nil {
+		policy = *f.Routing
+	}
+
+	if policy.Type == "" {
+		policy.Type = RoutingSimple
+	}
+
+	if f.HealthCheck != nil {
+		switch policy.Type {
+		case RoutingSimple:
+			policy.Type = RoutingMultiValue
+		case RoutingMultiValue:
+		default:
+			err = errors.Errorf(
+				"rule for record '%s' can't have a HealthCheck with %s routing",
+				f.Record, policy.Type)
+			return
+		}
+
+		// Route53 health checkers live outside of the
+		// VPCs, so they can't reach private addresses.
+		var addressType AddressType
+
+		addressType, err = f.AddressType()
+		if err != nil {
+			return
+		}
+
+		if addressType != AddressPublic && addressType != AddressElastic {
+			err = errors.Errorf(
+				"rule for record '%s' can only have a HealthCheck with public or elastic addresses - %s provided",
+				f.Record, addressType)
+			return
+		}
+	}
+
+	if policy.Type == RoutingMultiValue &&
+		(f.Type == RecordTypeCNAME || f.Alias != nil) {
+		err = errors.Errorf(
+			"rule for record '%s' can only have multivalue routing for address records",
+			f.Record)
+		return
+	}
+
+	switch policy.Type {
+	case RoutingSimple, RoutingMultiValue:
+		if policy.SetIdentifier != "" {
+			err = errors.Errorf(
+				"rule for record '%s' can't have a SetIdentifier with %s routing",
+				f.Record, policy.Type)
+			return
+		}
+	case RoutingWeighted, RoutingLatency, RoutingFailover:
+		if policy.SetIdentifier == "" {
+			err = errors.Errorf(
+				"rule for record '%s' requires a SetIdentifier for %s routing",
+				f.Record, policy.Type)
+			return
+		}
+	default:
+		err = errors.Errorf(
+			"rule for record '%s' has unknown routing %s",
+			f.Record, policy.Type)
+		return
+	}
+
+	if policy.Type == RoutingWeighted &&
+		(policy.Weight < 0 || policy.Weight > 255) {
+		err = errors.Errorf(
+			"rule for record '%s' must have a Weight between 0 and 255 - %d provided",
+			f.Record, policy.Weight)
+		return
+	}
+
+	if policy.Type == RoutingLatency && policy.Region == "" {
+		err = errors.Errorf(
+			"rule for record '%s' requires a Region for latency routing",
+			f.Record)
+		return
+	}
+
+	if policy.Type == RoutingFailover &&
+		policy.Failover != route53.ResourceRecordSetFailoverPrimary &&
+		policy.Failover != route53.ResourceRecordSetFailoverSecondary {
+		err = errors.Errorf(
+			"rule for record '%s' must have Failover PRIMARY or SECONDARY - '%s' provided",
+			f.Record, policy.Failover)
+		return
+	}
+
+	return
+}
+
+// NewRecord creates the (empty) record set named `name`
+// of type `recordType` that the rule produces for
+// `instance`, carrying the TTL and routing policy
+// attributes of the rule.
+func (f *FormattingRule) NewRecord(name, recordType string, instance *Instance) (record *Record, err error) {
+	policy, err := f.RoutingPolicy()
+	if err != nil {
+		return
+	}
+
+	if f.TTL < 0 {
+		err = errors.Errorf(
+			"rule for record '%s' must have a positive TTL - %d provided",
+			f.Record, f.TTL)
+		return
+	}
+
+	record = &Record{
+		Zone:        f.Zone,
+		Name:        name,
+		Type:        recordType,
+		TTL:         f.TTL,
+		Values:      []string{},
+		HealthCheck: f.HealthCheck,
+	}
+
+	if record.TTL == 0 {
+		record.TTL = DefaultTTL
+	}
+
+	record.Alias, err = f.InstanceAlias(instance)
+	if err != nil {
+		return
+	}
+
+	// alias record sets take the TTL of their target.
+	if record.Alias != nil {
+		record.TTL = 0
+	}
+
+	switch policy.Type {
+	case RoutingWeighted:
+		record.SetIdentifier = policy.SetIdentifier
+		record.Weight = &policy.Weight
+	case RoutingLatency:
+		record.SetIdentifier = policy.SetIdentifier
+		record.Region = policy.Region
+	case RoutingFailover:
+		record.SetIdentifier = policy.SetIdentifier
+		record.Failover = policy.Failover
+	case RoutingMultiValue:
+		record.SetIdentifier = instance.Id
+		record.MultiValueAnswer = true
+	}
+
+	return
+}
+
+// MembershipPolicy indicates which instances of an
+// autoscaling group are published.
+type MembershipPolicy string
+
+const (
+	// MembershipRunning admits instances in the EC2
+	// running state.
+	MembershipRunning MembershipPolicy = "running"
+
+	// MembershipInService admits running instances that
+	// are InService in their autoscaling group.
+	MembershipInService MembershipPolicy = "in-service"
+
+	// MembershipHealthy admits in-service instances that
+	// pass both EC2 status checks.
+	MembershipHealthy MembershipPolicy = "healthy"
+)
+
+// MembershipPolicy resolves the membership policy of
+// the rule.
+func (f *FormattingRule) MembershipPolicy() (policy MembershipPolicy, err error) {
+	policy = f.Membership
+
+	switch policy {
+	case "":
+		policy = MembershipRunning
+	case MembershipRunning:
+	case MembershipInService, MembershipHealthy:
+		if f.AutoScalingGroup == "" {
+			err = errors.Errorf(
+				"rule for record '%s' requires an AutoScalingGroup for %s membership",
+				f.Record, policy)
+			return
+		}
+	default:
+		err = errors.Errorf(
+			"rule for record '%s' has unknown Membership %s",
+			f.Record, policy)
+		return
+	}
+
+	return
+}
+
+// GroupName retrieves the name of the group of
+// instances that the rule selects from: its autoscaling
+// group (or pattern) or, for rules without one, its
+// selector.
+func (f *FormattingRule) GroupName() string {
+	if f.AutoScalingGroup == "" && f.Selector != nil {
+		return f.Selector.String()
+	}
+
+	return f.AutoScalingGroup
+}
+
+// ParseGroupPattern compiles the AutoScalingGroup of
+// the rule if it is a pattern.
+func (f *FormattingRule) ParseGroupPattern() (err error) {
+	f.groupPattern = nil
+
+	if !IsPattern(f.AutoScalingGroup) {
+		return
+	}
+
+	f.groupPattern, err = compilePattern(f.AutoScalingGroup)
+	return
+}
+
+// MatchesGroup indicates whether the autoscaling group
+// `name` is the one of the rule or, for patterns, one
+// that the pattern matches.
+//
+// ParseGroupPattern must have been called before.
+func (f *FormattingRule) MatchesGroup(name string) bool {
+	if strings.HasPrefix(name, selectorGroupPrefix) {
+		return false
+	}
+
+	if f.groupPattern != nil {
+		return f.groupPattern.MatchString(name)
+	}
+
+	return f.AutoScalingGroup != "" && f.AutoScalingGroup == name
+}
+
+// Admits indicates whether the rule's membership policy
+// (and selector) lets records point to the instance.
+func (f *FormattingRule) Admits(instance *Instance) (admitted bool, err error) {
+	policy, err := f.MembershipPolicy()
+	if err != nil {
+		return
+	}
+
+	admitted = instance.Running
+
+	if f.Selector != nil {
+		admitted = admitted && f.Selector.Matches(instance)
+	}
+
+	if policy == MembershipInService || policy == MembershipHealthy {
+		admitted = admitted &&
+			instance.LifecycleState == autoscaling.LifecycleStateInService
+	}
+
+	if policy == MembershipHealthy {
+		admitted = admitted && instance.Healthy
+	}
+
+	return
 }
 
 func (f *FormattingRule) ParseRecordTemplate() (err error) {
 	var tmpl *template.Template
 
-	tmpl, err = template.New("tmpl").Parse(f.Record)
+	err = f.ParseGroupPattern()
+	if err != nil {
+		return
+	}
+
+	tmpl, err = template.New("tmpl").Funcs(templateFuncs).Parse(f.Record)
 	if err != nil {
 		err = errors.Wrapf(err,
 			"failed to instantiate template for record '%s'",
@@ -147,13 +1242,43 @@ func (f *FormattingRule) ParseRecordTemplate() (err error) {
 	}
 
 	f.template = tmpl
+
+	tmpl, err = template.New("target").Funcs(templateFuncs).Parse(f.Target)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to instantiate template for target '%s'",
+			f.Target)
+		return
+	}
+
+	f.targetTemplate = tmpl
+
+	f.srvTemplates = nil
+	if f.SRV != nil {
+		for _, field := range []struct{ name, text string }{
+			{"priority", f.SRV.Priority},
+			{"weight", f.SRV.Weight},
+			{"port", f.SRV.Port},
+		} {
+			tmpl, err = template.New(field.name).Funcs(templateFuncs).Parse(field.text)
+			if err != nil {
+				err = errors.Wrapf(err,
+					"failed to instantiate template for SRV %s '%s'",
+					field.name, field.text)
+				return
+			}
+
+			f.srvTemplates = append(f.srvTemplates, tmpl)
+		}
+	}
+
 	return
 }
 
 func (f *FormattingRule) TemplateRecord(instance *Instance) (res string, err error) {
 	var buf = new(bytes.Buffer)
 
-	err = f.template.Execute(buf, instance)
+	err = f.execute(f.template, buf, instance)
 	if err != nil {
 		err = errors.Wrapf(err,
 			"failed to template record '%s' with instance data %+v",
@@ -161,7 +1286,50 @@ func (f *FormattingRule) TemplateRecord(instance *Instance) (res string, err err
 		return
 	}
 
-	res = buf.String()
+	// Route53 lists names in lowercase.
+	res = strings.ToLower(buf.String())
+
+	err = validateRecordName(res, f.Zone.Name)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"record '%s' rendered an invalid name for instance %s",
+			f.Record, instance.Id)
+		return
+	}
+
+	return
+}
+
+// TemplateTarget renders the Target of the rule for
+// `instance` as a fully qualified (lowercase) DNS name.
+func (f *FormattingRule) TemplateTarget(instance *Instance) (res string, err error) {
+	var buf = new(bytes.Buffer)
+
+	err = f.execute(f.targetTemplate, buf, instance)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to template target '%s' with instance data %+v",
+			f.Target, instance)
+		return
+	}
+
+	res = strings.ToLower(strings.TrimSuffix(buf.String(), "."))
+	if res == "" {
+		err = errors.Errorf(
+			"target '%s' is empty for instance %s",
+			f.Target, instance.Id)
+		return
+	}
+
+	err = validateDNSName(res)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"target '%s' rendered an invalid name for instance %s",
+			f.Target, instance.Id)
+		return
+	}
+
+	res += "."
 	return
 }
 
@@ -170,14 +1338,17 @@ func ShowAutoScalingGroupsTable(asgs map[string]*AutoScalingGroup) {
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
 
 	fmt.Println("AUTOSCALING GROUPS")
-	fmt.Fprintln(w, "NAME\tINSTANCE\tPRIVATE\tPUBLIC\t")
+	fmt.Fprintln(w, "NAME\tINSTANCE\tPRIVATE\tPUBLIC\tELASTIC\tSECONDARY\tIPV6\t")
 	for _, asg := range asgs {
 		for _, instance := range asg.Instances {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 				asg.Name,
 				instance.Id,
 				instance.PrivateIp,
-				instance.PublicIp)
+				instance.PublicIp,
+				instance.ElasticIp,
+				instance.SecondaryIp,
+				instance.Ipv6Ip)
 		}
 	}
 	w.Flush()
@@ -185,29 +1356,44 @@ func ShowAutoScalingGroupsTable(asgs map[string]*AutoScalingGroup) {
 }
 
 func ShowEvalsTable(evals []*Evaluation) {
-	var (
-		evalType string
-	)
+	var previous []string
 
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
 
 	fmt.Println("EVALS")
-	fmt.Fprintln(w, "TYPE\tRECORD\tVALUES\t")
+	fmt.Fprintln(w, "TYPE\tRECORD\tVALUES\tPREVIOUS\t")
 	for _, eval := range evals {
-		switch eval.Type {
-		case EvaluationAddRecord:
-			evalType = "create"
-		case EvaluationRemoveRecord:
-			evalType = "delete"
-		default:
-			panic(errors.Errorf("unknown eval type %+v", eval))
+		previous = nil
+		if eval.Previous != nil {
+			previous = recordValues(eval.Previous)
 		}
 
-		fmt.Fprintf(w, "%s\t%s\t%+v\n",
-			evalType,
-			eval.Record.Name,
-			eval.Record.IPs)
+		fmt.Fprintf(w, "%s\t%s\t%+v\t%+v\n",
+			eval.Type,
+			recordDisplayName(eval.Record),
+			recordValues(eval.Record),
+			previous)
 	}
 	w.Flush()
 }
+
+// recordValues retrieves the values of a record or,
+// for aliases, its target.
+func recordValues(record *Record) []string {
+	if record.Alias != nil {
+		return []string{"alias:" + record.Alias.DNSName}
+	}
+
+	return record.Values
+}
+
+// recordDisplayName retrieves the name of a record
+// followed by its set identifier, if any.
+func recordDisplayName(record *Record) string {
+	if record.SetIdentifier != "" {
+		return record.Name + " (" + record.SetIdentifier + ")"
+	}
+
+	return record.Name
+}
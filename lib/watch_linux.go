@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// watchFile notifies about changes to the directory of
+// the file through inotify, so that files replaced
+// (e.g., renamed over by editors or swapped through
+// symlinks, like Kubernetes ConfigMaps) are noticed too.
+func watchFile(file string) (notifications <-chan struct{}, stop func(), err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to initialize inotify")
+		return
+	}
+
+	wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(file),
+		syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE|
+			syscall.IN_DELETE|syscall.IN_MODIFY)
+	if err != nil {
+		syscall.Close(fd)
+		err = errors.Wrapf(err,
+			"failed to watch directory of %s",
+			file)
+		return
+	}
+
+	var (
+		events = make(chan struct{}, 1)
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		var buf = make([]byte, 4096)
+
+		defer syscall.Close(fd)
+
+		for {
+			_, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if err != nil {
+				return
+			}
+
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	notifications = events
+
+	// removing the watch queues an IN_IGNORED event,
+	// unblocking the read so that the descriptor can be
+	// closed.
+	stop = func() {
+		close(done)
+		syscall.InotifyRmWatch(fd, uint32(wd))
+	}
+
+	return
+}
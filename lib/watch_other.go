@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package lib
+
+import (
+	"github.com/pkg/errors"
+)
+
+// watchFile is not supported outside of Linux, making
+// the watcher poll the file instead.
+func watchFile(file string) (notifications <-chan struct{}, stop func(), err error) {
+	err = errors.Errorf("file notifications are not supported on this platform")
+	return
+}
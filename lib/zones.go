@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/pkg/errors"
+)
+
+// zoneCache holds the IDs of the hosted zones that
+// zones specified by name resolved to, so that they're
+// only looked up once.
+type zoneCache struct {
+	ids map[string]string
+	mtx sync.Mutex
+}
+
+func newZoneCache() *zoneCache {
+	return &zoneCache{
+		ids: map[string]string{},
+	}
+}
+
+// get retrieves the cached ID of the zone, if any.
+// A nil cache never holds any.
+func (c *zoneCache) get(key string) (id string, present bool) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	id, present = c.ids[key]
+	return
+}
+
+func (c *zoneCache) set(key, id string) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.ids[key] = id
+}
+
+// zoneCacheKey identifies a zone lookup by the name,
+// visibility and VPC of the zone.
+func zoneCacheKey(zone *Zone) string {
+	var private = "any"
+
+	if zone.Private != nil {
+		private = strconv.FormatBool(*zone.Private)
+	}
+
+	return normalizeZoneName(zone.Name) + "/" + private + "/" + zone.VPC
+}
+
+func normalizeZoneName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// resolveZones fills the IDs of the zones of the rules
+// that are only specified by name, normalizing the
+// names of all of them.
+func (a *Auto) resolveZones(rules []*FormattingRule) (err error) {
+	for _, rule := range rules {
+		rule.normalizeZones()
+
+		for _, zone := range rule.zoneRefs() {
+			if zone.Name == "" {
+				err = errors.Errorf(
+					"rule for record '%s' has a zone without Name",
+					rule.Record)
+				return
+			}
+
+			if zone.ID != "" {
+				continue
+			}
+
+			zone.ID, err = a.resolveZone(zone)
+			if err != nil {
+				err = errors.Wrapf(err,
+					"failed to resolve zone of record '%s'",
+					rule.Record)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// resolveZone looks up the ID of the hosted zone named
+// like the zone, picking between private and public
+// hosted zones (and between the VPCs of private ones)
+// as the zone specifies.
+//
+// Lookups that don't single out a hosted zone fail.
+func (a *Auto) resolveZone(zone *Zone) (id string, err error) {
+	var (
+		key        = zoneCacheKey(zone)
+		present    bool
+		candidates []*route53.HostedZone
+		matches    []string
+	)
+
+	id, present = a.zoneCache.get(key)
+	if present {
+		return
+	}
+
+	candidates, err = a.listHostedZonesByName(zone.Name)
+	if err != nil {
+		return
+	}
+
+	for _, hostedZone := range candidates {
+		var private = hostedZone.Config != nil &&
+			aws.BoolValue(hostedZone.Config.PrivateZone)
+
+		if zone.Private != nil && *zone.Private != private {
+			continue
+		}
+
+		if zone.VPC != "" {
+			var associated bool
+
+			if !private {
+				continue
+			}
+
+			associated, err = a.hostedZoneInVPC(hostedZone, zone.VPC)
+			if err != nil {
+				return
+			}
+
+			if !associated {
+				continue
+			}
+		}
+
+		matches = append(matches, hostedZoneId(hostedZone))
+	}
+
+	switch len(matches) {
+	case 0:
+		err = errors.Errorf(
+			"couldn't find hosted zone %s (private: %s, vpc: %s)",
+			zone.Name, zoneVisibility(zone), zone.VPC)
+		return
+	case 1:
+	default:
+		err = errors.Errorf(
+			"zone %s is ambiguous between hosted zones %s - set Private or VPC to pick one",
+			zone.Name, strings.Join(matches, ", "))
+		return
+	}
+
+	id = matches[0]
+	a.zoneCache.set(key, id)
+
+	a.logger.Info().
+		Str("zone", zone.Name).
+		Str("id", id).
+		Msg("resolved zone")
+
+	return
+}
+
+func zoneVisibility(zone *Zone) string {
+	if zone.Private == nil {
+		return "any"
+	}
+
+	return strconv.FormatBool(*zone.Private)
+}
+
+// listHostedZonesByName retrieves the hosted zones
+// named `name`, going through all the pages.
+func (a *Auto) listHostedZonesByName(name string) (hostedZones []*route53.HostedZone, err error) {
+	var (
+		input = &route53.ListHostedZonesByNameInput{
+			DNSName: aws.String(name),
+		}
+		result *route53.ListHostedZonesByNameOutput
+	)
+
+	for {
+		err = a.callRoute53(func() (err error) {
+			result, err = a.route53.ListHostedZonesByName(input)
+			return
+		})
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to list hosted zones named %s",
+				name)
+			return
+		}
+
+		// hosted zones are listed in order of name,
+		// starting at `name`.
+		for _, hostedZone := range result.HostedZones {
+			if normalizeZoneName(aws.StringValue(hostedZone.Name)) !=
+				normalizeZoneName(name) {
+				return
+			}
+
+			hostedZones = append(hostedZones, hostedZone)
+		}
+
+		if !aws.BoolValue(result.IsTruncated) {
+			return
+		}
+
+		input.DNSName = result.NextDNSName
+		input.HostedZoneId = result.NextHostedZoneId
+	}
+}
+
+// hostedZoneInVPC indicates whether the private hosted
+// zone is associated with the VPC.
+func (a *Auto) hostedZoneInVPC(hostedZone *route53.HostedZone, vpc string) (associated bool, err error) {
+	var result *route53.GetHostedZoneOutput
+
+	err = a.callRoute53(func() (err error) {
+		result, err = a.route53.GetHostedZone(&route53.GetHostedZoneInput{
+			Id: hostedZone.Id,
+		})
+		return
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to retrieve hosted zone %s",
+			hostedZoneId(hostedZone))
+		return
+	}
+
+	for _, v := range result.VPCs {
+		if aws.StringValue(v.VPCId) == vpc {
+			associated = true
+			return
+		}
+	}
+
+	return
+}
+
+// hostedZoneId retrieves the ID of the hosted zone
+// without the `/hostedzone/` prefix.
+func hostedZoneId(hostedZone *route53.HostedZone) string {
+	return strings.TrimPrefix(aws.StringValue(hostedZone.Id), "/hostedzone/")
+}
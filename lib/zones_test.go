@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHostedZone(id, name string, private bool) *route53.HostedZone {
+	return &route53.HostedZone{
+		Id:     aws.String("/hostedzone/" + id),
+		Name:   aws.String(name),
+		Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(private)},
+	}
+}
+
+func TestResolveZone(t *testing.T) {
+	var route53Client = &fakeRoute53{
+		hostedZones: []*route53.HostedZone{
+			newTestHostedZone("Z1", "example.com.", false),
+			newTestHostedZone("Z2", "example.com.", true),
+			newTestHostedZone("Z3", "example.com.", true),
+			newTestHostedZone("Z4", "example.org.", false),
+		},
+		hostedZoneVPCs: map[string][]string{
+			"/hostedzone/Z2": {"vpc-1"},
+			"/hostedzone/Z3": {"vpc-2"},
+		},
+	}
+
+	var testCases = []struct {
+		desc        string
+		zone        Zone
+		expected    string
+		shouldError bool
+	}{
+		{
+			desc:     "single hosted zone",
+			zone:     Zone{Name: "example.org"},
+			expected: "Z4",
+		},
+		{
+			desc:        "ambiguous name",
+			zone:        Zone{Name: "example.com"},
+			shouldError: true,
+		},
+		{
+			desc:     "public",
+			zone:     Zone{Name: "example.com", Private: aws.Bool(false)},
+			expected: "Z1",
+		},
+		{
+			desc:        "private in several vpcs",
+			zone:        Zone{Name: "example.com", Private: aws.Bool(true)},
+			shouldError: true,
+		},
+		{
+			desc:     "private in vpc",
+			zone:     Zone{Name: "example.com.", VPC: "vpc-2"},
+			expected: "Z3",
+		},
+		{
+			desc:        "missing",
+			zone:        Zone{Name: "example.net"},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			a := newTestAuto(nil)
+			a.route53 = route53Client
+
+			id, err := a.resolveZone(&tc.zone)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, id)
+		})
+	}
+}
+
+func TestResolveZonesCaches(t *testing.T) {
+	var route53Client = &fakeRoute53{
+		hostedZones: []*route53.HostedZone{
+			newTestHostedZone("Z1", "example.com.", false),
+			newTestHostedZone("Z2", "example.com.", true),
+		},
+	}
+
+	rules := []*FormattingRule{
+		{
+			Zone:   Zone{Name: "example.com", Private: aws.Bool(true)},
+			Record: "api",
+		},
+	}
+
+	a := newTestAuto(rules)
+	a.route53 = route53Client
+	a.zoneCache = newZoneCache()
+
+	require.NoError(t, a.resolveZones(rules))
+	assert.Equal(t, "Z2", rules[0].Zone.ID)
+
+	// rules reloaded from the configuration reuse the
+	// resolution.
+	rules[0].Zone.ID = ""
+
+	require.NoError(t, a.resolveZones(rules))
+	assert.Equal(t, "Z2", rules[0].Zone.ID)
+	assert.Equal(t, 1, route53Client.hostedZoneCalls)
+}
+
+func TestReconcileNormalizesZoneNames(t *testing.T) {
+	var testCases = []struct {
+		desc string
+		zone Zone
+	}{
+		{desc: "trailing dot", zone: Zone{ID: "Z1", Name: "apex1."}},
+		{desc: "uppercase", zone: Zone{ID: "Z1", Name: "APEX1"}},
+		{desc: "looked up by name", zone: Zone{Name: "Apex1."}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			route53Client := &fakeRoute53{
+				hostedZones: []*route53.HostedZone{
+					newTestHostedZone("Z1", "apex1.", false),
+				},
+				recordSets: []*route53.ResourceRecordSet{
+					newTestRecordSet("apex1.", "SOA", "ns1. admin. 1 7200 900 1209600 86400"),
+					newTestRecordSet("_auto53-a.web.apex1.", "TXT", ownershipValue(DefaultOwner)),
+					newTestRecordSet("web.apex1.", "A", "10.0.0.1"),
+				},
+			}
+
+			a := newTestAuto([]*FormattingRule{
+				{AutoScalingGroup: "asg1", Zone: tc.zone, Record: "web"},
+			})
+			a.route53 = route53Client
+			a.ec2 = &fakeEC2{
+				pages: [][]*ec2.Instance{
+					{newTestInstance("i-1", "asg1", "10.0.0.1")},
+				},
+			}
+
+			// records in place are left untouched.
+			res, err := a.Reconcile(false)
+			require.NoError(t, err)
+			assert.Empty(t, res.Evaluations)
+			assert.Equal(t, "apex1", a.FormattingRules()[0].Zone.Name)
+
+			// and missing ones are created under the zone.
+			route53Client.recordSets = route53Client.recordSets[:1]
+
+			res, err = a.Reconcile(false)
+			require.NoError(t, err)
+			require.Len(t, res.Evaluations, 1)
+
+			require.Len(t, route53Client.changes, 1)
+			for _, change := range route53Client.changes[0].ChangeBatch.Changes {
+				assert.Contains(t, []string{"web.apex1.", "_auto53-a.web.apex1."},
+					*change.ResourceRecordSet.Name)
+			}
+		})
+	}
+}
+
+func TestParseConfigNormalizesZoneNames(t *testing.T) {
+	rules, err := ParseConfig([]byte(`
+Version: 1
+Rules:
+  - AutoScalingGroup: 'asg1'
+    Zone: {Name: 'Example.COM.'}
+    Record: 'api'
+    Reverse:
+      IPv4: {Name: '10.IN-ADDR.ARPA.'}
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	assert.Equal(t, "example.com", rules[0].Zone.Name)
+	assert.Equal(t, "10.in-addr.arpa", rules[0].Reverse.IPv4.Name)
+}
+
+func TestResolveZonesRequiresNames(t *testing.T) {
+	a := newTestAuto(nil)
+	a.route53 = &fakeRoute53{}
+
+	err := a.resolveZones([]*FormattingRule{
+		{Zone: Zone{ID: "zone123"}, Record: "api"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rule for record 'api' has a zone without Name")
+}
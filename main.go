@@ -1,34 +1,52 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/cirocosta/auto53/lib"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
 type cliConfig struct {
-	Config   string        `arg:"help:path to the formatting rules configuration file`
-	Debug    bool          `arg:"help:activates debug-level logging"`
-	Dry      bool          `arg:"help:run without performing modifications"`
-	Interval time.Duration `arg:"help:interval between periodic state retrieval`
-	Listen   bool          `arg:"help:listen for API requests`
-	Once     bool          `arg:"help:run one time and exit"`
-	Port     int           `arg:"help:port to listen for API requests`
+	Command     string        `arg:"positional,help:validate checks the configuration without accessing AWS (reconciles if unset)"`
+	Concurrency int           `arg:"env:AUTO53_CONCURRENCY,help:maximum number of concurrent requests to each AWS service"`
+	Config      string        `arg:"env:AUTO53_CONFIG,help:path to the formatting rules configuration file"`
+	ConfigS3    string        `arg:"--config-s3,env:AUTO53_CONFIG_S3,help:s3://bucket/key of the formatting rules (instead of --config)"`
+	ConfigYaml  string        `arg:"--config-yaml,env:AUTO53_CONFIG_YAML,help:formatting rules yaml content (instead of --config)"`
+	Debug       bool          `arg:"env:AUTO53_DEBUG,help:activates debug-level logging"`
+	Dry         bool          `arg:"env:AUTO53_DRY,help:run without performing modifications"`
+	Interval    time.Duration `arg:"help:interval between periodic state retrieval"`
+	Listen      bool          `arg:"help:listen for API requests"`
+	Once        bool          `arg:"help:run one time and exit"`
+	Owner       string        `arg:"env:AUTO53_OWNER,help:identifier of this deployment in ownership records"`
+	Port        int           `arg:"help:port to listen for API requests"`
+	Wait        bool          `arg:"env:AUTO53_WAIT,help:wait for changes to propagate (INSYNC) failing otherwise"`
+	WaitTimeout time.Duration `arg:"--wait-timeout,env:AUTO53_WAIT_TIMEOUT,help:maximum time to wait for changes to propagate"`
+	SNS         bool          `arg:"--sns,help:receive autoscaling notifications from SNS under /sns"`
+	SNSTopic    []string      `arg:"--sns-topic,separate,help:SNS topic ARN allowed to notify (any if unset)"`
+	SQSQueue    string        `arg:"--sqs-queue,help:SQS queue URL to poll for autoscaling notifications"`
 }
 
 var (
 	args = &cliConfig{
-		Config:   "./auto53.yaml",
-		Debug:    false,
-		Dry:      false,
-		Interval: 2 * time.Minute,
-		Listen:   false,
-		Once:     false,
-		Port:     8080,
+		Concurrency: lib.DefaultConcurrency,
+		Config:      "./auto53.yaml",
+		Debug:       false,
+		Dry:         false,
+		Interval:    2 * time.Minute,
+		Listen:      false,
+		Once:        false,
+		Owner:       lib.DefaultOwner,
+		Port:        8080,
+		WaitTimeout: lib.DefaultWaitTimeout,
 	}
 	logger = zerolog.New(os.Stdout).
 		With().
@@ -46,46 +64,267 @@ func must(err error) {
 		Msg("main execution failed")
 }
 
-func main() {
-	arg.MustParse(args)
-
-	rules, err := lib.FormattingRulesFromYamlFile(args.Config)
+func runOnce(a *lib.Auto) {
+	res, err := a.Reconcile(args.Dry)
 	must(err)
 
-	a, err := lib.NewAuto(lib.AutoConfig{
-		Debug:           args.Debug,
-		FormattingRules: rules,
+	for _, result := range res.Results {
+		for _, change := range result.Changes {
+			logger.Info().
+				Str("zone", result.Zone).
+				Str("change", change.ID).
+				Str("status", change.Status).
+				Dur("propagation", change.Duration).
+				Msg("change submitted")
+		}
+	}
+
+	for _, conflict := range res.Conflicts {
+		logger.Warn().
+			Str("record", conflict.Name).
+			Str("zone", conflict.Zone.Name).
+			Msg("record name taken by a record not owned by auto53")
+	}
+
+	if !args.Dry {
+		return
+	}
+
+	fmt.Println("")
+	lib.ShowAutoScalingGroupsTable(res.AutoScalingGroups)
+
+	fmt.Println("")
+	lib.ShowEvalsTable(res.Evaluations)
+}
+
+// runValidate checks the formatting rules, reporting
+// every problem found on its own line.
+func runValidate() {
+	rules, err := loadFormattingRules()
+	if err == nil {
+		fmt.Printf("configuration is valid (%d rules)\n", len(rules))
+		return
+	}
+
+	configErrs, ok := errors.Cause(err).(lib.ConfigErrors)
+	if !ok {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, configErr := range configErrs {
+		fmt.Fprintln(os.Stderr, configErr)
+	}
+
+	os.Exit(1)
+}
+
+func runServer(a *lib.Auto) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		sigs        = make(chan os.Signal, 1)
+	)
+
+	reconciler, err := lib.NewReconciler(lib.ReconcilerConfig{
+		Auto:     a,
+		Interval: args.Interval,
+		Dry:      args.Dry,
 	})
 	must(err)
 
-	asgs, err := a.GetAutoScalingGroups()
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Info().
+			Str("signal", sig.String()).
+			Msg("signal received, shutting down")
+		cancel()
+	}()
+
+	go watchConfig(ctx, reconciler)
+
+	if args.Listen {
+		go serveAPI(ctx, reconciler)
+	}
+
+	if args.SQSQueue != "" {
+		poller, err := lib.NewSQSPoller(lib.SQSPollerConfig{
+			Reconciler: reconciler,
+			QueueURL:   args.SQSQueue,
+		})
+		must(err)
+
+		go poller.Run(ctx)
+	}
+
+	reconciler.Run(ctx)
+}
+
+// watchConfig reloads the formatting rules whenever the
+// configuration file changes or SIGHUP is received.
+func watchConfig(ctx context.Context, reconciler *lib.Reconciler) {
+	var (
+		cfg = lib.ConfigWatcherConfig{
+			Reconciler: reconciler,
+			Load:       loadFormattingRules,
+		}
+		hups = make(chan os.Signal, 1)
+	)
+
+	// only files are watched, while rules given inline
+	// or in S3 are reloaded on SIGHUP.
+	if args.ConfigYaml == "" && args.ConfigS3 == "" {
+		cfg.File = args.Config
+	}
+
+	watcher, err := lib.NewConfigWatcher(cfg)
 	must(err)
 
-	currentRecords := []*lib.Record{}
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hups:
+				logger.Info().Msg("SIGHUP received, reloading formatting rules")
+				watcher.Reload()
+			}
+		}
+	}()
+
+	watcher.Run(ctx)
+}
+
+func serveAPI(ctx context.Context, reconciler *lib.Reconciler) {
+	var (
+		sns *lib.SNSHandler
+		err error
+	)
 
-	zonesRecords, err := a.GetZonesRecords()
+	if args.SNS {
+		sns, err = lib.NewSNSHandler(lib.SNSHandlerConfig{
+			Reconciler: reconciler,
+			TopicARNs:  args.SNSTopic,
+		})
+		must(err)
+	}
+
+	api, err := lib.NewAPI(lib.APIConfig{
+		Reconciler: reconciler,
+		SNS:        sns,
+	})
 	must(err)
 
-	for _, records := range zonesRecords {
-		currentRecords = append(currentRecords, records...)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", args.Port),
+		Handler: api,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(
+			context.Background(), 10*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info().
+		Int("port", args.Port).
+		Msg("listening for API requests")
+
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		must(err)
+	}
+}
+
+func newAuto(rules []*lib.FormattingRule) (a lib.Auto, err error) {
+	a, err = lib.NewAuto(lib.AutoConfig{
+		Concurrency:     args.Concurrency,
+		Debug:           args.Debug,
+		FormattingRules: rules,
+		Owner:           args.Owner,
+		WaitInSync:      args.Wait,
+		WaitTimeout:     args.WaitTimeout,
+	})
+	return
+}
+
+func loadFormattingRules() (rules []*lib.FormattingRule, err error) {
+	switch {
+	case args.ConfigYaml != "":
+		rules, err = lib.FormattingRulesFromYaml([]byte(args.ConfigYaml))
+	case args.ConfigS3 != "":
+		rules, err = lib.FormattingRulesFromS3(args.ConfigS3)
+	default:
+		rules, err = lib.FormattingRulesFromYamlFile(args.Config)
 	}
 
-	desiredRecords, err := lib.CreateRecords(asgs, rules)
+	return
+}
+
+// runLambda serves invocations of a lambda function
+// using the custom runtime API, performing a single
+// pass per invocation.
+func runLambda(api string) {
+	runtime, err := lib.NewLambdaRuntime(api)
 	must(err)
 
-	evals, err := lib.GetEvaluations(currentRecords, desiredRecords)
+	rules, err := loadFormattingRules()
+	if err != nil {
+		runtime.InitError(err)
+		must(err)
+	}
+
+	a, err := newAuto(rules)
+	if err != nil {
+		runtime.InitError(err)
+		must(err)
+	}
+
+	handler, err := lib.NewLambdaHandler(lib.LambdaHandlerConfig{
+		Auto: &a,
+		Dry:  args.Dry,
+	})
 	must(err)
 
-	if args.Dry {
-		fmt.Println("")
-		lib.ShowAutoScalingGroupsTable(asgs)
+	must(runtime.Serve(handler.Handle))
+}
 
-		fmt.Println("")
-		lib.ShowEvalsTable(evals)
+func main() {
+	p := arg.MustParse(args)
+	if args.SNS && !args.Listen {
+		p.Fail("--sns requires --listen")
+	}
+
+	switch args.Command {
+	case "":
+	case "validate":
+		runValidate()
 		return
+	default:
+		p.Fail("unknown command " + args.Command)
 	}
 
-	err = a.ExecuteEvaluations(evals)
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api != "" {
+		runLambda(api)
+		return
+	}
+
+	rules, err := loadFormattingRules()
 	must(err)
 
+	a, err := newAuto(rules)
+	must(err)
+
+	if args.Once {
+		runOnce(&a)
+		return
+	}
+
+	runServer(&a)
 }